@@ -1,16 +1,234 @@
 package amiibo
 
-import "sync"
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
-var once sync.Once
+	"dio.wtf/joycontrol/joycontrol/fileutil"
+)
 
-func init() {
-	once.Do(func() {
-		mcu = &MicroControllerUnit{}
-	})
+// https://www.nxp.com/docs/en/data-sheet/NTAG213_215_216.pdf
+
+const (
+	PageSize  = 4
+	PageCount = 135
+	TagSize   = PageSize * PageCount // NTAG215
+
+	UidLength = 7
+
+	pwdPage = 0x85
+)
+
+// Dumps may carry the 32 bytes NXP signature, or miss the PWD/PACK
+// pages.
+var dumpSizes = []int{TagSize, TagSize + 32, TagSize - 8}
+
+var ErrPageOutOfRange = errors.New("amiibo page out of range")
+
+// Tag is the memory of an NTAG215 amiibo.
+type Tag struct {
+	mux   sync.RWMutex
+	data  [TagSize]byte
+	extra []byte // Bytes after the tag memory in the dump
+
+	size    int
+	path    string
+	backups int
+}
+
+// Load reads an amiibo dump, usually a .bin file. Writes from the
+// console are saved back to it.
+func Load(path string) (*Tag, error) {
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	t, err := Parse(data)
+	if nil != err {
+		return nil, err
+	}
+	t.path = path
+	return t, nil
 }
 
-var mcu *MicroControllerUnit
+func Parse(data []byte) (*Tag, error) {
+	valid := false
+	for _, size := range dumpSizes {
+		valid = valid || len(data) == size
+	}
+	if !valid {
+		return nil, fmt.Errorf("bad amiibo dump size %d, expect %d", len(data), TagSize)
+	}
+
+	t := &Tag{size: len(data)}
+	copy(t.data[:], data)
+	if len(data) > TagSize {
+		t.extra = append([]byte{}, data[TagSize:]...)
+	}
+	return t, nil
+}
+
+// SetBackups keeps up to n timestamped copies of the dump, made
+// before each save. 0 disables backups.
+func (t *Tag) SetBackups(n int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.backups = n
+}
+
+func (t *Tag) Path() string {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return t.path
+}
+
+// UID returns the 7 bytes serial number, pages 0-1 without the
+// BCC0 check byte.
+func (t *Tag) UID() []byte {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	uid := make([]byte, 0, UidLength)
+	uid = append(uid, t.data[0:3]...)
+	uid = append(uid, t.data[4:8]...)
+	return uid
+}
+
+// Data returns a copy of the whole tag memory.
+func (t *Tag) Data() []byte {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	data := make([]byte, TagSize)
+	copy(data, t.data[:])
+	return data
+}
+
+// Clone returns a copy of the tag kept in memory only, writes to it
+// are not saved to the dump.
+func (t *Tag) Clone() *Tag {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return &Tag{data: t.data, extra: append([]byte{}, t.extra...), size: t.size}
+}
+
+// Nickname returns the name given to the amiibo on a console, the
+// user data is only readable with the master keys.
+func (t *Tag) Nickname(keys *Keys) (string, error) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	plain, err := keys.unpack(t.data[:])
+	if nil != err {
+		return "", err
+	}
+	return nickname(plain), nil
+}
+
+// RandomizeUID gives the tag a new NXP serial number, for games
+// limiting scans of the same figure. The dump is signed again for
+// the new UID and detached from its file, writes are kept in memory
+// only.
+func (t *Tag) RandomizeUID(keys *Keys) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	plain, err := keys.unpack(t.data[:])
+	if nil != err {
+		return err
+	}
+	uid := make([]byte, UidLength)
+	if _, err = rand.Read(uid); nil != err {
+		return err
+	}
+	uid[0] = 0x04 // NXP manufacturer code
+
+	// Pages 0-2 start with UID0-2, BCC0, UID3-6 then BCC1
+	serial := plain[uidOffset : uidOffset+8]
+	copy(serial[0:3], uid[0:3])
+	serial[3] = 0x88 ^ uid[0] ^ uid[1] ^ uid[2]
+	copy(serial[4:8], uid[3:7])
+	plain[0] = uid[3] ^ uid[4] ^ uid[5] ^ uid[6]
+	keys.pack(plain, t.data[:])
+
+	// The NTAG password is also derived from the UID
+	pwd := t.data[pwdPage*PageSize:]
+	pwd[0] = 0xAA ^ uid[1] ^ uid[3]
+	pwd[1] = 0x55 ^ uid[2] ^ uid[4]
+	pwd[2] = 0xAA ^ uid[3] ^ uid[5]
+	pwd[3] = 0x55 ^ uid[4] ^ uid[6]
+
+	t.extra = nil // The NXP signature no longer matches
+	t.path = ""
+	return nil
+}
+
+// Write stores data starting at page, like the NTAG WRITE command
+// it must be a multiple of PageSize.
+func (t *Tag) Write(page int, data []byte) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if page < 0 || page*PageSize+len(data) > TagSize {
+		return ErrPageOutOfRange
+	}
+	copy(t.data[page*PageSize:], data)
+	return nil
+}
+
+// Save writes the tag back to the dump it was loaded from, tags
+// not loaded from a file are kept in memory only.
+func (t *Tag) Save() error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.path == "" {
+		return nil
+	}
+	if err := t.backup(); nil != err {
+		return err
+	}
+
+	size := t.size
+	if size > TagSize {
+		size = TagSize
+	}
+	data := append(append([]byte{}, t.data[:size]...), t.extra...)
+	return fileutil.WriteFile(t.path, data)
+}
+
+func (t *Tag) backup() error {
+	if t.backups <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if nil != err {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.%s.bak", t.path, time.Now().Format("20060102-150405.000"))
+	if err = os.WriteFile(name, data, 0644); nil != err {
+		return err
+	}
 
-type MicroControllerUnit struct {
+	backups, err := filepath.Glob(t.path + ".*.bak")
+	if nil != err {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > t.backups {
+		if err = os.Remove(backups[0]); nil != err {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
 }
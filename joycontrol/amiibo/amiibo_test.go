@@ -0,0 +1,34 @@
+package amiibo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(path, make([]byte, TagSize), 0644); nil != err {
+		t.Fatal(err)
+	}
+	tag, err := Load(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	clone := tag.Clone()
+	if clone.Path() != "" || !bytes.Equal(clone.Data(), tag.Data()) {
+		t.Errorf("got clone of %q", clone.Path())
+	}
+	// Writes stay in the clone memory
+	if err = clone.Write(0x10, []byte{0xA5, 0x00, 0x01, 0x00}); nil != err {
+		t.Fatal(err)
+	}
+	if err = clone.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); data[0x40] != 0x00 || tag.Data()[0x40] != 0x00 {
+		t.Error("clone write reached the original")
+	}
+}
@@ -0,0 +1,219 @@
+package amiibo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unicode/utf16"
+)
+
+// https://github.com/socram8888/amiitool
+
+// KeysSize is the size of key_retail.bin, the unfixed infos keys
+// followed by the locked secret keys.
+const KeysSize = 2 * masterKeysSize
+
+const (
+	masterKeysSize = 80
+	internalSize   = 0x208 // Tag pages 0x00-0x81, signed and encrypted
+
+	dataHmacOffset = 0x008
+	tagHmacOffset  = 0x1B4
+	uidOffset      = 0x1D4 // UID with its BCC0 check byte
+	nicknameOffset = 0x038
+	settingsOffset = 0x02C
+)
+
+var ErrBadSignature = errors.New("amiibo signature mismatch, wrong keys or corrupt dump")
+
+// masterKeys derive the keys of a dump, one set for the user data
+// and one for the locked pages.
+type masterKeys struct {
+	hmacKey    []byte
+	typeString []byte // With its NUL terminator
+	magic      []byte
+	xorPad     []byte
+}
+
+// derivedKeys encrypt and sign one dump.
+type derivedKeys struct {
+	aesKey  []byte
+	aesIV   []byte
+	hmacKey []byte
+}
+
+// Keys are the amiibo master keys, which Nintendo doesn't publish
+// and must be dumped from a console.
+type Keys struct {
+	data masterKeys // Unfixed infos, encrypts and signs the user data
+	tag  masterKeys // Locked secret, signs the locked pages
+}
+
+func LoadKeys(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	return ParseKeys(data)
+}
+
+func ParseKeys(data []byte) (*Keys, error) {
+	if len(data) != KeysSize {
+		return nil, fmt.Errorf("bad amiibo keys size %d, expect %d", len(data), KeysSize)
+	}
+	k := &Keys{}
+	var err error
+	if k.data, err = parseMasterKeys(data[:masterKeysSize]); nil != err {
+		return nil, err
+	}
+	if k.tag, err = parseMasterKeys(data[masterKeysSize:]); nil != err {
+		return nil, err
+	}
+	return k, nil
+}
+
+func parseMasterKeys(data []byte) (masterKeys, error) {
+	// HMAC key, 14 bytes type string, 1 unused byte, magic size,
+	// 16 bytes magic and 32 bytes XOR pad
+	magicSize := int(data[31])
+	if magicSize > 16 {
+		return masterKeys{}, fmt.Errorf("bad amiibo keys magic size %d", magicSize)
+	}
+	typeString := data[16:30]
+	for i, b := range typeString {
+		if b == 0 {
+			typeString = typeString[:i+1]
+			break
+		}
+	}
+	return masterKeys{
+		hmacKey:    data[0:16],
+		typeString: typeString,
+		magic:      data[32 : 32+magicSize],
+		xorPad:     data[48:80],
+	}, nil
+}
+
+// derive generates the keys of an internal dump, seeded by its
+// write counter, UID and keygen salt.
+func (m masterKeys) derive(internal []byte) derivedKeys {
+	seed := make([]byte, 0, 2+14+16+16+16+32)
+	seed = append(seed, 0x00, 0x00) // DRBG iteration
+	seed = append(seed, m.typeString...)
+	// The magic replaces the end of the write counter padded to 16
+	counter := make([]byte, 16)
+	copy(counter, internal[0x029:0x02B])
+	seed = append(seed, counter[:16-len(m.magic)]...)
+	seed = append(seed, m.magic...)
+	seed = append(seed, internal[uidOffset:uidOffset+8]...)
+	seed = append(seed, internal[uidOffset:uidOffset+8]...)
+	for i := 0; i < 32; i++ {
+		seed = append(seed, internal[0x1E8+i]^m.xorPad[i])
+	}
+
+	var out []byte
+	for i := uint16(0); len(out) < 48; i++ {
+		binary.BigEndian.PutUint16(seed, i)
+		out = append(out, sign(m.hmacKey, seed)...)
+	}
+	return derivedKeys{aesKey: out[0:16], aesIV: out[16:32], hmacKey: out[32:48]}
+}
+
+// crypt encrypts or decrypts the user data of an internal dump into
+// out, the signatures are left untouched.
+func (d derivedKeys) crypt(in, out []byte) {
+	block, _ := aes.NewCipher(d.aesKey)
+	cipher.NewCTR(block, d.aesIV).XORKeyStream(out[0x02C:tagHmacOffset], in[0x02C:tagHmacOffset])
+	copy(out[0x000:dataHmacOffset], in[0x000:dataHmacOffset])
+	copy(out[0x028:0x02C], in[0x028:0x02C])
+	copy(out[uidOffset:internalSize], in[uidOffset:internalSize])
+}
+
+func sign(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// unpack decrypts a dump and checks its signatures, the result is
+// in the internal order of the amiibo library.
+func (k *Keys) unpack(tag []byte) ([]byte, error) {
+	internal := toInternal(tag)
+	dataKeys := k.data.derive(internal)
+	tagKeys := k.tag.derive(internal)
+
+	plain := make([]byte, internalSize)
+	dataKeys.crypt(internal, plain)
+	// The data signature covers the tag signature
+	copy(plain[tagHmacOffset:], sign(tagKeys.hmacKey, plain[uidOffset:internalSize]))
+	copy(plain[dataHmacOffset:], sign(dataKeys.hmacKey, plain[0x029:internalSize]))
+
+	if !hmac.Equal(plain[dataHmacOffset:dataHmacOffset+32], internal[dataHmacOffset:dataHmacOffset+32]) ||
+		!hmac.Equal(plain[tagHmacOffset:tagHmacOffset+32], internal[tagHmacOffset:tagHmacOffset+32]) {
+		return nil, ErrBadSignature
+	}
+	return plain, nil
+}
+
+// pack signs and encrypts an unpacked dump back into tag.
+func (k *Keys) pack(plain []byte, tag []byte) {
+	dataKeys := k.data.derive(plain)
+	tagKeys := k.tag.derive(plain)
+
+	internal := make([]byte, internalSize)
+	copy(internal[tagHmacOffset:], sign(tagKeys.hmacKey, plain[uidOffset:internalSize]))
+	copy(internal[dataHmacOffset:], sign(dataKeys.hmacKey,
+		plain[0x029:tagHmacOffset], internal[tagHmacOffset:uidOffset], plain[uidOffset:internalSize]))
+	dataKeys.crypt(plain, internal)
+	fromInternal(internal, tag)
+}
+
+// internalLayout maps ranges of the internal order to the tag:
+// internal offset, tag offset and size.
+var internalLayout = [][3]int{
+	{0x000, 0x008, 0x008},
+	{0x008, 0x080, 0x020}, // Data signature
+	{0x028, 0x010, 0x024},
+	{0x04C, 0x0A0, 0x168},
+	{0x1B4, 0x034, 0x020}, // Tag signature
+	{0x1D4, 0x000, 0x008},
+	{0x1DC, 0x054, 0x02C},
+}
+
+func toInternal(tag []byte) []byte {
+	internal := make([]byte, internalSize)
+	for _, r := range internalLayout {
+		copy(internal[r[0]:r[0]+r[2]], tag[r[1]:])
+	}
+	return internal
+}
+
+func fromInternal(internal []byte, tag []byte) {
+	for _, r := range internalLayout {
+		copy(tag[r[1]:r[1]+r[2]], internal[r[0]:])
+	}
+}
+
+// nickname decodes the UTF-16BE nickname of an unpacked dump, empty
+// until the amiibo is set up on a console.
+func nickname(plain []byte) string {
+	if plain[settingsOffset]&0x10 == 0 {
+		return ""
+	}
+	var chars []uint16
+	for i := nicknameOffset; i < nicknameOffset+20; i += 2 {
+		c := binary.BigEndian.Uint16(plain[i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars))
+}
@@ -0,0 +1,111 @@
+package amiibo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// testKeys returns made up master keys laid out like key_retail.bin.
+func testKeys(t *testing.T) *Keys {
+	data := make([]byte, KeysSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	copy(data[16:30], "unfixed infos\x00")
+	data[31] = 14
+	copy(data[masterKeysSize+16:], "locked secret\x00")
+	data[masterKeysSize+31] = 16
+
+	keys, err := ParseKeys(data)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// signedDump returns a dump set up with nickname and signed by keys.
+func signedDump(keys *Keys, name string) []byte {
+	plain := make([]byte, internalSize)
+	copy(plain[uidOffset:], []byte{0x04, 0x11, 0x22, 0x88 ^ 0x04 ^ 0x11 ^ 0x22, 0x33, 0x44, 0x55, 0x66})
+	plain[0] = 0x33 ^ 0x44 ^ 0x55 ^ 0x66
+	plain[0x028] = 0xA5
+	plain[settingsOffset] = 0x10
+	for i, c := range utf16.Encode([]rune(name)) {
+		binary.BigEndian.PutUint16(plain[nicknameOffset+i*2:], c)
+	}
+	copy(plain[0x1DC:], []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02})
+	for i := 0x1E8; i < internalSize; i++ {
+		plain[i] = byte(i)
+	}
+
+	tag := make([]byte, TagSize)
+	keys.pack(plain, tag)
+	return tag
+}
+
+func TestParseKeys(t *testing.T) {
+	if _, err := ParseKeys(make([]byte, KeysSize-1)); nil == err {
+		t.Error("expect error for short keys")
+	}
+	data := make([]byte, KeysSize)
+	data[31] = 17
+	if _, err := ParseKeys(data); nil == err {
+		t.Error("expect error for bad magic size")
+	}
+}
+
+func TestKeysPack(t *testing.T) {
+	keys := testKeys(t)
+	dump := signedDump(keys, "Mario")
+	plain, err := keys.unpack(dump)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nickname(plain) != "Mario" {
+		t.Errorf("got nickname %q", nickname(plain))
+	}
+	// The identification and UID stay in plain text
+	if !bytes.Equal(dump[0:3], []byte{0x04, 0x11, 0x22}) || dump[identificationOffset+7] != 0x02 {
+		t.Errorf("got dump % X", dump[:0x60])
+	}
+	if bytes.Contains(dump, []byte{0x00, 'M', 0x00, 'a'}) {
+		t.Error("nickname not encrypted")
+	}
+
+	repacked := make([]byte, TagSize)
+	keys.pack(plain, repacked)
+	if !bytes.Equal(repacked, dump) {
+		t.Error("unpacked dump doesn't pack back the same")
+	}
+
+	dump[0xA0] ^= 0x01
+	if _, err = keys.unpack(dump); err != ErrBadSignature {
+		t.Errorf("got %v for tampered dump, expect ErrBadSignature", err)
+	}
+}
+
+func TestRandomizeUID(t *testing.T) {
+	keys := testKeys(t)
+	tag, err := Parse(signedDump(keys, "Link"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	uid := tag.UID()
+	if err = tag.RandomizeUID(keys); nil != err {
+		t.Fatal(err)
+	}
+
+	data := tag.Data()
+	if bytes.Equal(tag.UID(), uid) || data[0] != 0x04 {
+		t.Errorf("got UID % X", tag.UID())
+	}
+	if data[3] != 0x88^data[0]^data[1]^data[2] || data[8] != data[4]^data[5]^data[6]^data[7] {
+		t.Errorf("bad check bytes in % X", data[0:9])
+	}
+	// Signed again for the new UID
+	if name, err := tag.Nickname(keys); nil != err || name != "Link" {
+		t.Errorf("got nickname %q, %v", name, err)
+	}
+}
@@ -0,0 +1,153 @@
+package amiibo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// https://www.3dbrew.org/wiki/Amiibo
+
+// identificationOffset is the start of pages 0x15-0x16, the only
+// plain text identification of the figure.
+const identificationOffset = 0x15 * PageSize
+
+// Identification tells which figure a dump is, the nickname and
+// other user data are encrypted with the master Keys.
+type Identification struct {
+	GameCharacter uint16 // Game series and character
+	Variant       byte
+	FigureType    byte // 0x00 figure, 0x01 card, 0x02 yarn
+	Model         uint16
+	Series        byte // amiibo series
+}
+
+// String formats the identification like amiibo databases, the last
+// byte is always 0x02.
+func (i Identification) String() string {
+	return fmt.Sprintf("%04X%02X%02X-%04X%02X02", i.GameCharacter, i.Variant, i.FigureType, i.Model, i.Series)
+}
+
+func (t *Tag) Identification() Identification {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	id := t.data[identificationOffset:]
+	return Identification{
+		GameCharacter: binary.BigEndian.Uint16(id[0:2]),
+		Variant:       id[2],
+		FigureType:    id[3],
+		Model:         binary.BigEndian.Uint16(id[4:6]),
+		Series:        id[6],
+	}
+}
+
+// Entry is a dump indexed by a Library, named after its nickname
+// or else the character and figure it identifies.
+type Entry struct {
+	Name     string
+	Path     string
+	ID       Identification
+	Nickname string // Empty without keys or before set up on a console
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s (%s)", e.Name, filepath.Base(e.Path))
+}
+
+// Library indexes the amiibo dumps of a directory.
+type Library struct {
+	dir     string
+	keys    *Keys
+	entries []Entry
+	current int // Entry placed by Swap, -1 if none
+}
+
+// OpenLibrary indexes the dumps of dir, nicknames are read when keys
+// is not nil.
+func OpenLibrary(dir string, keys *Keys) (*Library, error) {
+	l := &Library{dir: dir, keys: keys, current: -1}
+	return l, l.Reload()
+}
+
+// Reload indexes the directory again, files which are not valid
+// dumps are skipped.
+func (l *Library) Reload() error {
+	files, err := os.ReadDir(l.dir)
+	if nil != err {
+		return err
+	}
+
+	entries := make([]Entry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.EqualFold(filepath.Ext(file.Name()), ".bin") {
+			continue
+		}
+		path := filepath.Join(l.dir, file.Name())
+		tag, err := Load(path)
+		if nil != err {
+			continue
+		}
+		entry := Entry{Path: path, ID: tag.Identification()}
+		if l.keys != nil {
+			// Dumps signed with other keys are still listed
+			entry.Nickname, _ = tag.Nickname(l.keys)
+		}
+		entry.Name = entry.Nickname
+		if entry.Name == "" {
+			entry.Name = entry.ID.String()
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	l.entries = entries
+	l.current = -1
+	return nil
+}
+
+func (l *Library) Entries() []Entry {
+	return l.entries
+}
+
+// Find looks up an entry by nickname, identification or file name
+// without extension, ignoring case.
+func (l *Library) Find(query string) (Entry, bool) {
+	for _, entry := range l.entries {
+		file := filepath.Base(entry.Path)
+		for _, name := range []string{entry.Nickname, entry.ID.String(), strings.TrimSuffix(file, filepath.Ext(file))} {
+			if name != "" && strings.EqualFold(name, query) {
+				return entry, true
+			}
+		}
+	}
+	return Entry{}, false
+}
+
+func (l *Library) Load(entry Entry) (*Tag, error) {
+	return Load(entry.Path)
+}
+
+// Swap loads the entry step places after the last one swapped in,
+// wrapping around, a negative step goes backward.
+func (l *Library) Swap(step int) (*Tag, Entry, error) {
+	if len(l.entries) == 0 {
+		return nil, Entry{}, os.ErrNotExist
+	}
+	if l.current < 0 && step < 0 {
+		l.current = 0 // Going backward from none starts at the last
+	}
+	n := len(l.entries)
+	l.current = ((l.current+step)%n + n) % n
+
+	entry := l.entries[l.current]
+	tag, err := l.Load(entry)
+	return tag, entry, err
+}
@@ -0,0 +1,111 @@
+package amiibo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDump saves a blank dump identified as figure id.
+func writeDump(t *testing.T, dir, name string, id []byte) {
+	data := make([]byte, TagSize)
+	copy(data[identificationOffset:], id)
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenLibrary(t *testing.T) {
+	dir := t.TempDir()
+	writeDump(t, dir, "mario.bin", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02})
+	writeDump(t, dir, "link.bin", []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02})
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a dump"), 0644)
+	os.WriteFile(filepath.Join(dir, "short.bin"), []byte{0x04}, 0644)
+
+	library, err := OpenLibrary(dir, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	entries := library.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, expect 2", len(entries))
+	}
+	// Named after the identification at page 0x15, not the file
+	if entries[0].Name != "00000000-00000002" || entries[1].Name != "01000000-00010002" {
+		t.Errorf("got names %q, %q", entries[0].Name, entries[1].Name)
+	}
+	if entries[1].ID.GameCharacter != 0x0100 || entries[1].ID.Model != 0x0001 {
+		t.Errorf("got identification %+v", entries[1].ID)
+	}
+	if entry, ok := library.Find("link"); !ok || entry.Name != entries[1].Name {
+		t.Errorf("find by file name: got %v %v", entry, ok)
+	}
+	if _, ok := library.Find("00000000-00000002"); !ok {
+		t.Error("find by name failed")
+	}
+}
+
+func TestLibrarySwap(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := (&Library{current: -1}).Swap(1); nil == err {
+		t.Error("expect error for empty library")
+	}
+	for i, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		writeDump(t, dir, name, []byte{0x00, byte(i)})
+	}
+	library, err := OpenLibrary(dir, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		step int
+		file string
+	}{
+		{1, "a.bin"},
+		{1, "b.bin"},
+		{1, "c.bin"},
+		{1, "a.bin"}, // Wraps around
+		{-1, "c.bin"},
+		{-2, "a.bin"},
+	} {
+		tag, entry, err := library.Swap(test.step)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if filepath.Base(entry.Path) != test.file || tag.Path() != entry.Path {
+			t.Errorf("swap %d: got %s, expect %s", test.step, entry.Path, test.file)
+		}
+	}
+
+	if err := library.Reload(); nil != err {
+		t.Fatal(err)
+	}
+	if _, entry, _ := library.Swap(-1); filepath.Base(entry.Path) != "c.bin" {
+		t.Errorf("swap back after reload: got %s, expect c.bin", entry.Path)
+	}
+}
+
+func TestLibraryNicknames(t *testing.T) {
+	dir := t.TempDir()
+	keys := testKeys(t)
+	if err := os.WriteFile(filepath.Join(dir, "a.bin"), signedDump(keys, "Mario"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	writeDump(t, dir, "b.bin", []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02})
+
+	library, err := OpenLibrary(dir, keys)
+	if nil != err {
+		t.Fatal(err)
+	}
+	entries := library.Entries()
+	if len(entries) != 2 || entries[0].Name != "01000000-00010002" || entries[1].Name != "Mario" {
+		t.Fatalf("got entries %+v", entries)
+	}
+	if entry, ok := library.Find("mario"); !ok || filepath.Base(entry.Path) != "a.bin" {
+		t.Errorf("find by nickname: got %v %v", entry, ok)
+	}
+	if _, ok := library.Find(entries[1].ID.String()); !ok {
+		t.Error("find by identification failed")
+	}
+}
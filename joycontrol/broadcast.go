@@ -0,0 +1,138 @@
+package joycontrol
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	C "dio.wtf/joycontrol/joycontrol/controller"
+	"dio.wtf/joycontrol/joycontrol/log"
+)
+
+// DefaultMaxLag is how long a console may not take reports before a
+// Broadcast drops it.
+const DefaultMaxLag = 2 * time.Second
+
+// ConsoleStatus is the state of a console a Broadcast mirrors to.
+type ConsoleStatus struct {
+	ControllerStatus
+	Dropped bool
+	Lag     time.Duration
+}
+
+// Broadcast mirrors the input of one controller to every controller
+// of a Manager, each connected to its own console. Consoles keep
+// their own negotiated state, only the input is shared.
+type Broadcast struct {
+	source  *C.Controller
+	manager *Manager
+	maxLag  time.Duration
+
+	mux     sync.RWMutex
+	dropped map[*Server]bool // By server, the manager may grow
+	stop    chan struct{}
+}
+
+// NewBroadcast mirrors source to the controllers of manager, which
+// must be of the same type.
+func NewBroadcast(source *C.Controller, manager *Manager) (*Broadcast, error) {
+	for i := 0; i < manager.Len(); i++ {
+		if t := manager.Controller(i).Type; t != source.Type {
+			return nil, fmt.Errorf("cannot mirror %s to %s", source.Type, t)
+		}
+	}
+	return &Broadcast{
+		source:  source,
+		manager: manager,
+		maxLag:  DefaultMaxLag,
+		dropped: make(map[*Server]bool),
+	}, nil
+}
+
+// SetMaxLag sets how long a console may lag before it is dropped, 0
+// never drops consoles.
+func (b *Broadcast) SetMaxLag(lag time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.maxLag = lag
+}
+
+// Start mirrors the input at the report rate until Stop.
+func (b *Broadcast) Start() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.stop != nil {
+		return
+	}
+	b.stop = make(chan struct{})
+	go b.run(b.stop)
+}
+
+func (b *Broadcast) Stop() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+}
+
+// Drop disconnects console i and stops mirroring to it, the other
+// consoles are not affected.
+func (b *Broadcast) Drop(i int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	server := b.manager.Server(i)
+	if b.dropped[server] {
+		return
+	}
+	b.dropped[server] = true
+	server.Disconnect()
+}
+
+func (b *Broadcast) Status() []ConsoleStatus {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	controllers := b.manager.Status()
+	status := make([]ConsoleStatus, len(controllers))
+	for i, controller := range controllers {
+		server := b.manager.Server(i)
+		status[i] = ConsoleStatus{
+			ControllerStatus: controller,
+			Dropped:          b.dropped[server],
+			Lag:              server.Lag(),
+		}
+	}
+	return status
+}
+
+func (b *Broadcast) run(stop chan struct{}) {
+	ticker := time.NewTicker(time.Second / 66)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		b.mux.RLock()
+		maxLag := b.maxLag
+		b.mux.RUnlock()
+
+		state := b.source.InputState()
+		for i, status := range b.Status() {
+			if status.Dropped || !status.Connected {
+				continue
+			}
+			if maxLag > 0 && status.Lag > maxLag {
+				log.ErrorF("console %s lags %v, dropping it", status.Console, status.Lag)
+				b.Drop(i)
+				continue
+			}
+			b.manager.Controller(i).SetInputState(state)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package joycontrol
+
+import (
+	"testing"
+
+	C "dio.wtf/joycontrol/joycontrol/controller"
+	"golang.org/x/sys/unix"
+)
+
+func TestBroadcastDropAddedServer(t *testing.T) {
+	first, itr, _ := newTestServer(t, C.NewController(C.ProController))
+	defer unix.Close(itr)
+	manager := &Manager{servers: []*Server{first}, status: make([]ControllerStatus, 1)}
+	b, err := NewBroadcast(C.NewController(C.ProController), manager)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// A server added after the broadcast is mirrored and dropped too
+	added, itr, _ := newTestServer(t, C.NewController(C.ProController))
+	defer unix.Close(itr)
+	manager.servers = append(manager.servers, added)
+	manager.status = append(manager.status, ControllerStatus{})
+
+	b.Drop(1)
+	status := b.Status()
+	if len(status) != 2 || status[0].Dropped || !status[1].Dropped {
+		t.Errorf("got status %+v", status)
+	}
+	if !added.dropRequested() || first.dropRequested() {
+		t.Error("wrong server dropped")
+	}
+}
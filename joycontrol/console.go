@@ -0,0 +1,235 @@
+package joycontrol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dio.wtf/joycontrol/joycontrol/fileutil"
+	"dio.wtf/joycontrol/joycontrol/log"
+	"golang.org/x/sys/unix"
+)
+
+// BlueZ keeps link keys in the info file of each paired device.
+const bluezStorageDir = "/var/lib/bluetooth"
+
+var ErrUnknownConsole = errors.New("unknown console")
+
+// Console is a Switch the controller connected to.
+type Console struct {
+	Address       string    `json:"address"`
+	Adapter       string    `json:"adapter"`     // Address of the adapter used
+	DevicePath    string    `json:"device_path"` // BlueZ object of the console
+	LinkKey       bool      `json:"link_key"`    // BlueZ stored a link key, reconnecting needs it
+	Player        int       `json:"player"`      // Last player slot, 0 if unknown
+	LastConnected time.Time `json:"last_connected"`
+}
+
+// ConsoleStore keeps the known consoles in a JSON file.
+type ConsoleStore struct {
+	mux     sync.Mutex
+	path    string
+	saving  bool // A background save is pending
+	version int  // Counts the saves
+
+	writeMux sync.Mutex
+	written  int // Version in the file
+
+	Consoles []Console `json:"consoles"`
+	Default  string    `json:"default,omitempty"`
+}
+
+// OpenConsoleStore loads the store at path, a missing file is an
+// empty store created on the first update.
+func OpenConsoleStore(path string) (*ConsoleStore, error) {
+	s := &ConsoleStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if nil != err {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, s); nil != err {
+		return nil, fmt.Errorf("bad console store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// List returns the known consoles, most recently connected first.
+func (s *ConsoleStore) List() []Console {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	consoles := append([]Console{}, s.Consoles...)
+	sort.SliceStable(consoles, func(i, j int) bool {
+		return consoles[i].LastConnected.After(consoles[j].LastConnected)
+	})
+	return consoles
+}
+
+func (s *ConsoleStore) Get(address string) (Console, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if i := s.index(address); i >= 0 {
+		return s.Consoles[i], true
+	}
+	return Console{}, false
+}
+
+// Update adds or replaces the console with the same address.
+func (s *ConsoleStore) Update(console Console) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	console.Address = strings.ToUpper(console.Address)
+	if i := s.index(console.Address); i >= 0 {
+		s.Consoles[i] = console
+	} else {
+		s.Consoles = append(s.Consoles, console)
+	}
+	return s.save()
+}
+
+// SetPlayer records the player slot of a known console. The store is
+// saved in the background, player lights handlers must not block.
+func (s *ConsoleStore) SetPlayer(address string, player int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	i := s.index(address)
+	if i < 0 || s.Consoles[i].Player == player {
+		return
+	}
+	s.Consoles[i].Player = player
+	if !s.saving {
+		s.saving = true
+		go s.saveLater()
+	}
+}
+
+// saveLater writes the store as it is by then, changes made
+// meanwhile are saved together.
+func (s *ConsoleStore) saveLater() {
+	s.mux.Lock()
+	s.saving = false
+	s.version++
+	version := s.version
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mux.Unlock()
+	if nil == err {
+		err = s.write(data, version)
+	}
+	if nil != err {
+		log.ErrorF("save consoles %s: %v", s.path, err)
+	}
+}
+
+func (s *ConsoleStore) Forget(address string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	i := s.index(address)
+	if i < 0 {
+		return ErrUnknownConsole
+	}
+	s.Consoles = append(s.Consoles[:i], s.Consoles[i+1:]...)
+	if strings.EqualFold(s.Default, address) {
+		s.Default = ""
+	}
+	return s.save()
+}
+
+// SetDefault chooses the console to reconnect to, "" falls back to
+// the most recently connected one.
+func (s *ConsoleStore) SetDefault(address string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if address != "" && s.index(address) < 0 {
+		return ErrUnknownConsole
+	}
+	s.Default = strings.ToUpper(address)
+	return s.save()
+}
+
+// DefaultConsole returns the console to reconnect to.
+func (s *ConsoleStore) DefaultConsole() (Console, bool) {
+	s.mux.Lock()
+	address := s.Default
+	s.mux.Unlock()
+
+	if address != "" {
+		if console, ok := s.Get(address); ok {
+			return console, true
+		}
+	}
+	consoles := s.List()
+	if len(consoles) == 0 {
+		return Console{}, false
+	}
+	return consoles[0], true
+}
+
+func (s *ConsoleStore) index(address string) int {
+	for i, console := range s.Consoles {
+		if strings.EqualFold(console.Address, address) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *ConsoleStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if nil != err {
+		return err
+	}
+	s.version++
+	return s.write(data, s.version)
+}
+
+// write replaces the file with data of version, unless a newer one
+// was written meanwhile.
+func (s *ConsoleStore) write(data []byte, version int) error {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if version <= s.written {
+		return nil
+	}
+	if err := fileutil.WriteFile(s.path, data); nil != err {
+		return err
+	}
+	s.written = version
+	return nil
+}
+
+// hasLinkKey tells if BlueZ stored a link key for the console.
+func hasLinkKey(adapter, console string) bool {
+	info, err := os.ReadFile(filepath.Join(bluezStorageDir, strings.ToUpper(adapter), strings.ToUpper(console), "info"))
+	if nil != err {
+		return false
+	}
+	return strings.Contains(string(info), "[LinkKey]")
+}
+
+// sockaddrAddress returns the MAC address of an accepted L2CAP peer,
+// which is in the little-endian order of the kernel.
+func sockaddrAddress(sa unix.Sockaddr) string {
+	l2, ok := sa.(*unix.SockaddrL2)
+	if !ok {
+		return ""
+	}
+	parts := make([]string, len(l2.Addr))
+	for i, b := range l2.Addr {
+		parts[len(l2.Addr)-1-i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
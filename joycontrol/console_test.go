@@ -0,0 +1,101 @@
+package joycontrol
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "dio.wtf/joycontrol/joycontrol/controller"
+	"golang.org/x/sys/unix"
+)
+
+func TestConsoleStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "consoles.json")
+	store, err := OpenConsoleStore(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, ok := store.DefaultConsole(); ok {
+		t.Error("empty store has a default console")
+	}
+
+	now := time.Now()
+	store.Update(Console{Address: "98:b6:e9:00:00:01", Player: 1, LastConnected: now.Add(-time.Hour)})
+	store.Update(Console{Address: "98:B6:E9:00:00:02", Player: 2, LastConnected: now})
+	store.Update(Console{Address: "98:B6:E9:00:00:01", Player: 3, LastConnected: now.Add(-time.Minute)})
+
+	store, err = OpenConsoleStore(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	consoles := store.List()
+	if len(consoles) != 2 || consoles[0].Address != "98:B6:E9:00:00:02" || consoles[1].Player != 3 {
+		t.Errorf("unexpected consoles: %+v", consoles)
+	}
+	if console, _ := store.DefaultConsole(); console.Address != "98:B6:E9:00:00:02" {
+		t.Errorf("got default %s, expect the last connected", console.Address)
+	}
+
+	if err = store.SetDefault("98:b6:e9:00:00:01"); nil != err {
+		t.Fatal(err)
+	}
+	if console, _ := store.DefaultConsole(); console.Address != "98:B6:E9:00:00:01" {
+		t.Errorf("got default %s, expect the chosen one", console.Address)
+	}
+	if err = store.SetDefault("00:00:00:00:00:00"); err != ErrUnknownConsole {
+		t.Errorf("got %v, expect ErrUnknownConsole", err)
+	}
+
+	if err = store.Forget("98:B6:E9:00:00:01"); nil != err {
+		t.Fatal(err)
+	}
+	if store.Default != "" || len(store.List()) != 1 {
+		t.Errorf("console not forgotten: %+v", store)
+	}
+}
+
+func TestRecordPlayer(t *testing.T) {
+	store, err := OpenConsoleStore(filepath.Join(t.TempDir(), "consoles.json"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	store.Update(Console{Address: "98:B6:E9:00:00:01", Player: 1})
+
+	ctrl := C.NewController(C.ProController)
+	s, itr, _ := newTestServer(t, ctrl)
+	defer unix.Close(itr)
+	s.SetConsoleStore(store)
+
+	// Lights set after dialing update the record
+	s.setPeer("98:B6:E9:00:00:01")
+	ctrl.SetPlayerLights(0x03)
+	if console, _ := store.Get("98:B6:E9:00:00:01"); console.Player != 2 {
+		t.Errorf("got player %d, expect 2", console.Player)
+	}
+	ctrl.SetPlayerLights(0xF0) // Searching
+	if console, _ := store.Get("98:B6:E9:00:00:01"); console.Player != 2 {
+		t.Errorf("got player %d after searching lights, expect 2", console.Player)
+	}
+
+	// Saved in the background
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		saved, err := OpenConsoleStore(store.path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if console, _ := saved.Get("98:B6:E9:00:00:01"); console.Player == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("player slot not saved")
+		}
+	}
+}
+
+func TestSockaddrAddress(t *testing.T) {
+	// Accept returns the address in kernel order
+	sa := &unix.SockaddrL2{Addr: [6]byte{0x93, 0xDC, 0xC4, 0x32, 0xA6, 0xDC}}
+	if addr := sockaddrAddress(sa); addr != "DC:A6:32:C4:DC:93" {
+		t.Errorf("got %s", addr)
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+// BatteryLevel is the high nibble of the battery and connection byte
+// in standard reports, the lowest bit of it is the charging flag.
+type BatteryLevel uint8
+
+const (
+	BatteryEmpty    BatteryLevel = 0x0
+	BatteryCritical BatteryLevel = 0x2
+	BatteryLow      BatteryLevel = 0x4
+	BatteryMedium   BatteryLevel = 0x6
+	BatteryFull     BatteryLevel = 0x8
+)
+
+func (b BatteryLevel) String() string {
+	switch b {
+	case BatteryEmpty:
+		return "Empty"
+	case BatteryCritical:
+		return "Critical"
+	case BatteryLow:
+		return "Low"
+	case BatteryMedium:
+		return "Medium"
+	case BatteryFull:
+		return "Full"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Voltage is the regulated voltage answered to subcommand 0x50, at
+// the lower bound of the level's range: 0x528-0x59F critical,
+// 0x5A0-0x5DF low, 0x5E0-0x617 medium and 0x618-0x690 full. Empty
+// is below critical.
+func (b BatteryLevel) Voltage() uint16 {
+	switch b {
+	case BatteryCritical:
+		return 0x528
+	case BatteryLow:
+		return 0x5A0
+	case BatteryMedium:
+		return 0x5E0
+	case BatteryFull:
+		return 0x618
+	default:
+		return 0x500
+	}
+}
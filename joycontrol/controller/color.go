@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type RGB [3]byte
+
+// ParseRGB reads colors written as "#RRGGBB" or "RRGGBB".
+func ParseRGB(s string) (c RGB, err error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if nil != err || len(b) != 3 {
+		return c, fmt.Errorf("bad color %q, expect #RRGGBB", s)
+	}
+	copy(c[:], b)
+	return c, nil
+}
+
+func (c RGB) String() string {
+	return fmt.Sprintf("#%02X%02X%02X", c[0], c[1], c[2])
+}
+
+// Colors shown for the controller in the Switch menus, grips are
+// only used by Pro Controllers.
+type Colors struct {
+	Body      RGB
+	Buttons   RGB
+	LeftGrip  RGB
+	RightGrip RGB
+}
+
+// Bytes returns the layout stored at 0x6050 of the SPI flash.
+func (c Colors) Bytes() []byte {
+	data := make([]byte, 0, 12)
+	data = append(data, c.Body[:]...)
+	data = append(data, c.Buttons[:]...)
+	data = append(data, c.LeftGrip[:]...)
+	data = append(data, c.RightGrip[:]...)
+	return data
+}
+
+var DefaultColors = map[ControllerType]Colors{
+	JoyConL:       {Body: RGB{0x0A, 0xB9, 0xE6}, Buttons: RGB{0x00, 0x1E, 0x1E}}, // Neon blue
+	JoyConR:       {Body: RGB{0xFF, 0x3C, 0x28}, Buttons: RGB{0x1E, 0x0A, 0x0A}}, // Neon red
+	ProController: {Body: RGB{0x32, 0x32, 0x32}, Buttons: RGB{0xFF, 0xFF, 0xFF}, LeftGrip: RGB{0x32, 0x32, 0x32}, RightGrip: RGB{0x32, 0x32, 0x32}},
+	NesL:          {Body: RGB{0xB4, 0xB4, 0xB4}, Buttons: RGB{0x96, 0x1E, 0x1E}}, // Gray, red buttons
+	NesR:          {Body: RGB{0xB4, 0xB4, 0xB4}, Buttons: RGB{0x96, 0x1E, 0x1E}},
+	Snes:          {Body: RGB{0xC8, 0xC8, 0xD2}, Buttons: RGB{0x50, 0x46, 0x8C}}, // Light gray, purple buttons
+	N64:           {Body: RGB{0x32, 0x32, 0x32}, Buttons: RGB{0x1E, 0x46, 0xB4}}, // Charcoal, blue buttons
+	Genesis:       {Body: RGB{0x1E, 0x1E, 0x1E}, Buttons: RGB{0x3C, 0x3C, 0x3C}}, // Black
+}
@@ -1,12 +1,18 @@
 package controller
 
 import (
+	"image"
+	"sync"
+
+	"dio.wtf/joycontrol/joycontrol/amiibo"
+	"dio.wtf/joycontrol/joycontrol/log"
 	R "dio.wtf/joycontrol/joycontrol/report"
 )
 
 // https://github.com/dekuNukem/Nintendo_Switch_Reverse_Engineering/blob/master/bluetooth_hid_notes.md
 
 type Controller struct {
+	Type ControllerType
 	Mode R.InputReportMode
 
 	DeviceInfoRequired bool
@@ -14,92 +20,471 @@ type Controller struct {
 	VibrationEnabled   bool
 	PlayerNumber       bool
 
-	Dirty bool
-	bs    *ButtonState
-	mcu   *MicroControllerUnit
+	Dirty  bool
+	mux    sync.RWMutex
+	bs     *ButtonState
+	sticks [2]*StickState
+	flash  *SpiFlash
+	imu    *Imu
+	mcu    *MicroControllerUnit
+
+	battery       BatteryLevel
+	charging      bool
+	gripConnected bool
+
+	rumble         R.RumbleData
+	rumbleHandlers []func(R.RumbleData)
+
+	lights         PlayerLights
+	lightsHandlers []func(PlayerLights)
+
+	homeLight         HomeLight
+	homeLightHandlers []func(HomeLight)
 }
 
-func NewController() *Controller {
+func NewController(t ControllerType) *Controller {
 	return &Controller{
+		Type:    t,
+		battery: BatteryFull,
+		rumble:  R.RumbleData{Left: R.DefaultRumble, Right: R.DefaultRumble},
 		bs: &ButtonState{
-			data: [3]byte{},
+			data:   [3]byte{},
+			layout: buttonMaps[t],
 		},
+		sticks: [2]*StickState{NewStickState(), NewStickState()},
+		flash:  NewSpiFlash(t),
+		imu:    NewImu(),
 		mcu: &MicroControllerUnit{
 			mode:       McuStandby,
 			powerState: McuSuspend,
+			ir:         NewIrCamera(),
 		},
 	}
 }
 
+// Disconnect forgets the state negotiated with the console, which
+// sets it up again when it reconnects.
+func (c *Controller) Disconnect() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Mode = 0
+	c.DeviceInfoRequired = false
+	c.ImuEnabled = false
+	c.VibrationEnabled = false
+	c.PlayerNumber = false
+	c.mcu.SetState(McuStandby)
+	c.mcu.TogglePowerState(false)
+}
+
 func (c *Controller) Press(buttons ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.Dirty = true
 	c.bs.press(buttons...)
 }
 
 func (c *Controller) Release(buttons ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.Dirty = true
 	c.bs.release(buttons...)
 }
 
+// SetStick moves the stick to raw 12-bit positions, see StickMin,
+// StickCenter and StickMax.
+func (c *Controller) SetStick(stick Stick, x, y uint16) {
+	if !stick.valid() || !c.Type.HasStick(stick) {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.sticks[stick].Set(x, y)
+}
+
+// SetStickNormalized moves the stick to positions in range [-1, 1].
+func (c *Controller) SetStickNormalized(stick Stick, x, y float64) {
+	if !stick.valid() || !c.Type.HasStick(stick) {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.sticks[stick].SetNormalized(x, y)
+}
+
+// SetStickPolar moves the stick by angle in degrees and magnitude
+// in range [0, 1].
+func (c *Controller) SetStickPolar(stick Stick, angle, magnitude float64) {
+	if !stick.valid() || !c.Type.HasStick(stick) {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.sticks[stick].SetPolar(angle, magnitude)
+}
+
+func (c *Controller) CenterStick(stick Stick) {
+	if !stick.valid() || !c.Type.HasStick(stick) {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.sticks[stick].Center()
+}
+
+// StickState returns the packed left and right stick data, a stick
+// missing on the controller type is reported as zeros.
+func (c *Controller) StickState() (left, right [3]byte) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if c.Type.HasStick(LeftStick) {
+		left = c.sticks[LeftStick].Bytes()
+	}
+	if c.Type.HasStick(RightStick) {
+		right = c.sticks[RightStick].Bytes()
+	}
+	return
+}
+
+// SetImu reports a constant motion reading, accelerometer in G and
+// gyroscope in degrees per second.
+func (c *Controller) SetImu(sample ImuSample) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.imu.Set(sample)
+}
+
+// PushImuSamples feeds motion readings taken 5ms apart, each report
+// carries the latest 3 of them.
+func (c *Controller) PushImuSamples(samples ...ImuSample) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.imu.Push(samples...)
+}
+
+// SetImuSensitivity applies the ranges chosen by the console with
+// subcommand 0x41.
+func (c *Controller) SetImuSensitivity(args []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.imu.SetSensitivity(args[0], args[1], args[2], args[3])
+}
+
+func (c *Controller) WriteImuRegister(addr, value byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.imu.WriteRegister(addr, value)
+}
+
+func (c *Controller) ReadImuRegisters(addr, count byte) []byte {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.imu.ReadRegisters(addr, count)
+}
+
+// ImuData returns the encoded samples for a standard report.
+func (c *Controller) ImuData() []byte {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.imu.Bytes()
+}
+
+func (c *Controller) SetBattery(level BatteryLevel, charging bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.battery = level
+	c.charging = charging
+}
+
+func (c *Controller) Battery() (level BatteryLevel, charging bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.battery, c.charging
+}
+
+// SetGripConnected reports the controller as attached to and
+// powered by a charging grip.
+func (c *Controller) SetGripConnected(connected bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Dirty = true
+	c.gripConnected = connected
+}
+
+// Status is the battery level and connection info byte of input
+// reports.
+func (c *Controller) Status() byte {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	status := byte(c.battery)<<4 | c.Type.ConnectionInfo()
+	if c.charging {
+		status |= 0x10
+	}
+	if c.gripConnected {
+		status |= 0x01 // Switch/USB powered
+	}
+	return status
+}
+
+// Voltage is the regulated voltage of the battery, in units of 2.5mV.
+func (c *Controller) Voltage() uint16 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.battery.Voltage()
+}
+
+// SetColors changes the colors shown in the Switch menus, they are
+// read from the SPI flash when the controller connects.
+func (c *Controller) SetColors(colors Colors) error {
+	return c.flash.WriteColors(c.Type, colors)
+}
+
+func (c *Controller) Colors() Colors {
+	return c.flash.Colors()
+}
+
+// OnRumble registers a handler called from the report loop whenever
+// the console changes the rumble, it must not block.
+func (c *Controller) OnRumble(handler func(R.RumbleData)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.rumbleHandlers = append(c.rumbleHandlers, handler)
+}
+
+// UpdateRumble stores the rumble of an output report and notifies
+// handlers when it changed.
+func (c *Controller) UpdateRumble(rumble R.RumbleData) {
+	c.mux.Lock()
+	if rumble == c.rumble {
+		c.mux.Unlock()
+		return
+	}
+	c.rumble = rumble
+	handlers := c.rumbleHandlers
+	c.mux.Unlock()
+
+	for _, handler := range handlers {
+		handler(rumble)
+	}
+}
+
+func (c *Controller) Rumble() R.RumbleData {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.rumble
+}
+
+// OnPlayerLights registers a handler called from the report loop
+// whenever the console sets the player lights, it must not block.
+func (c *Controller) OnPlayerLights(handler func(PlayerLights)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.lightsHandlers = append(c.lightsHandlers, handler)
+}
+
+func (c *Controller) SetPlayerLights(lights PlayerLights) {
+	c.mux.Lock()
+	c.PlayerNumber = true
+	c.lights = lights
+	handlers := c.lightsHandlers
+	c.mux.Unlock()
+
+	for _, handler := range handlers {
+		handler(lights)
+	}
+}
+
+func (c *Controller) PlayerLights() PlayerLights {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.lights
+}
+
+// Player returns the player slot 1-8 assigned by the console, or 0
+// before one is assigned.
+func (c *Controller) Player() int {
+	return c.PlayerLights().Player()
+}
+
+// OnHomeLight registers a handler called from the report loop
+// whenever the console sets the HOME LED, it must not block.
+func (c *Controller) OnHomeLight(handler func(HomeLight)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.homeLightHandlers = append(c.homeLightHandlers, handler)
+}
+
+func (c *Controller) SetHomeLight(light HomeLight) {
+	c.mux.Lock()
+	c.homeLight = light
+	handlers := c.homeLightHandlers
+	c.mux.Unlock()
+
+	for _, handler := range handlers {
+		handler(light)
+	}
+}
+
+func (c *Controller) HomeLight() HomeLight {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.homeLight
+}
+
+func (c *Controller) Flash() *SpiFlash {
+	return c.flash
+}
+
+// SetFlash replaces the emulated SPI flash, e.g. with a dump loaded
+// by LoadSpiFlash.
+func (c *Controller) SetFlash(flash *SpiFlash) {
+	c.flash = flash
+}
+
+// SetMcuState switches the MCU mode, IR mode is refused by types
+// without an IR camera.
 func (c *Controller) SetMcuState(state McuMode) {
+	if state == McuIr && !c.Type.HasIrCamera() {
+		log.DebugF("%s has no IR camera", c.Type)
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.mcu.SetState(state)
 }
 
 func (c *Controller) ToggleMcuPower(on bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.mcu.TogglePowerState(on)
 }
 
 func (c *Controller) McuState() []byte {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
 	return c.mcu.StateData()
 }
 
+// SetNfcTag places an amiibo on the NFC reader, replacing the one
+// already there, nil removes it.
+func (c *Controller) SetNfcTag(tag *amiibo.Tag) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.mcu.SetTag(tag)
+}
+
+func (c *Controller) NfcTag() *amiibo.Tag {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.mcu.tag
+}
+
+// SetIrFrames replaces the images seen by the IR camera, nil shows
+// a dark scene.
+func (c *Controller) SetIrFrames(frames []*image.Gray) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.mcu.ir.SetFrames(frames)
+}
+
+func (c *Controller) ConfigureIr(args []byte) []byte {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.mcu.ConfigureIr(args)
+}
+
+func (c *Controller) HandleMcuRequest(cmd R.McuCommand, args []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.mcu.HandleRequest(cmd, args)
+}
+
+// McuData returns the MCU payload of the next 0x31 report.
+func (c *Controller) McuData() []byte {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.mcu.Data()
+}
+
+// InputState is what the player does with the controller, as
+// opposed to the state negotiated with the console.
+type InputState struct {
+	Buttons  [3]byte
+	Sticks   [2]StickState
+	Imu      [imuSampleCount]ImuSample
+	Battery  BatteryLevel
+	Charging bool
+	Grip     bool
+}
+
+func (c *Controller) InputState() InputState {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return InputState{
+		Buttons:  c.bs.data,
+		Sticks:   [2]StickState{*c.sticks[LeftStick], *c.sticks[RightStick]},
+		Imu:      c.imu.samples,
+		Battery:  c.battery,
+		Charging: c.charging,
+		Grip:     c.gripConnected,
+	}
+}
+
+// SetInputState replaces the input with the one of another controller
+// of the same type, e.g. to mirror it.
+func (c *Controller) SetInputState(state InputState) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.bs.data != state.Buttons || *c.sticks[LeftStick] != state.Sticks[LeftStick] ||
+		*c.sticks[RightStick] != state.Sticks[RightStick] || c.imu.samples != state.Imu ||
+		c.battery != state.Battery || c.charging != state.Charging || c.gripConnected != state.Grip {
+		c.Dirty = true
+	}
+	c.bs.data = state.Buttons
+	*c.sticks[LeftStick] = state.Sticks[LeftStick]
+	*c.sticks[RightStick] = state.Sticks[RightStick]
+	c.imu.samples = state.Imu
+	c.battery = state.Battery
+	c.charging = state.Charging
+	c.gripConnected = state.Grip
+}
+
 func (c *Controller) Dump() []byte {
+	data, _ := c.DumpChanged()
+	return data
+}
+
+// DumpChanged returns the buttons like Dump, and whether the input
+// changed since the last dump.
+func (c *Controller) DumpChanged() ([]byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	dirty := c.Dirty
 	c.Dirty = false
-	return c.bs.data[:]
-}
-
-// | Byte       | x01 | x02 | x04    | x08    | x10 | x20    | x40 | x80         |
-// |:----------:|:---:|:---:|:------:|:------:|:---:|:------:|:---:|:-----------:|
-// | 3 (Right)  | Y   | X   | B      | A      | SR  | SL     | R   | ZR          |
-// | 4 (Shared) | -   | +   | R Stick| L Stick| Home| Capture| --  |Charging Grip|
-// | 5 (Left)   | Down| Up  | Right  | Left   | SR  | SL     | L   | ZL          |
-var buttonMap = map[string]struct {
-	index int
-	bit   int
-}{
-	"Y": {0, 0},
-	"X": {0, 1},
-	"B": {0, 2},
-	"A": {0, 3},
-	// "SR": {0, 4},
-	// "SL": {0, 5},
-	"R":  {0, 6},
-	"ZR": {0, 7},
-	"+":  {1, 0},
-	"-":  {1, 1},
-	// "RStick":       {1, 2},
-	// "LStick":       {1, 3},
-	"Home":         {1, 4},
-	"Capture":      {1, 5},
-	"ChargingGrip": {1, 7},
-	"DOWN":         {2, 0},
-	"UP":           {2, 1},
-	"RIGHT":        {2, 2},
-	"LEFT":         {2, 3},
-	// "SR":    {2, 4},
-	// "SL":    {2, 5},
-	"L":  {2, 6},
-	"ZL": {2, 7},
+	data := c.bs.data
+	if c.gripConnected {
+		data[1] |= 1 << 7 // Charging Grip
+	}
+	return data[:], dirty
 }
 
 type ButtonState struct {
-	data [3]byte
+	data   [3]byte
+	layout map[string]button
 }
 
 func (b *ButtonState) press(buttons ...string) {
 	for i := range buttons {
 		button := buttons[i]
-		if info, ok := buttonMap[button]; ok {
+		if info, ok := b.layout[button]; ok {
 			// Check if button is already pressed
 			if (b.data[info.index]>>info.bit)&1 != 1 {
 				b.data[info.index] ^= 1 << info.bit
@@ -111,7 +496,7 @@ func (b *ButtonState) press(buttons ...string) {
 func (b *ButtonState) release(buttons ...string) {
 	for i := range buttons {
 		button := buttons[i]
-		if info, ok := buttonMap[button]; ok {
+		if info, ok := b.layout[button]; ok {
 			// Check if button is pressed
 			if (b.data[info.index]>>info.bit)&1 == 1 {
 				b.data[info.index] ^= 1 << info.bit
@@ -1,11 +1,20 @@
 package controller
 
 import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"dio.wtf/joycontrol/joycontrol/amiibo"
+	R "dio.wtf/joycontrol/joycontrol/report"
 )
 
 func TestButtonAction(t *testing.T) {
-	c := NewController()
+	c := NewController(ProController)
 
 	c.Press("UP")
 	b := c.Dump()
@@ -14,3 +23,385 @@ func TestButtonAction(t *testing.T) {
 	b = c.Dump()
 	t.Log(b)
 }
+
+func TestStickState(t *testing.T) {
+	c := NewController(ProController)
+
+	left, right := c.StickState()
+	if left != [3]byte{0x00, 0x08, 0x80} || right != left {
+		t.Errorf("unexpected centered stick data: %v %v", left, right)
+	}
+
+	c.SetStick(LeftStick, 0x123, 0x456)
+	left, _ = c.StickState()
+	if left != [3]byte{0x23, 0x61, 0x45} {
+		t.Errorf("unexpected stick data: % X", left)
+	}
+
+	c.SetStickNormalized(RightStick, 1, -1)
+	_, right = c.StickState()
+	if right != [3]byte{0xFF, 0x0F, 0x00} {
+		t.Errorf("unexpected stick data: % X", right)
+	}
+
+	// Unknown sticks are ignored
+	c.SetStick(Stick(2), StickMax, StickMax)
+	c.CenterStick(Stick(2))
+}
+
+func TestSpiFlash(t *testing.T) {
+	flash := NewSpiFlash(ProController)
+
+	if data := flash.Read(DeviceTypeAddr, 1); data[0] != 0x03 {
+		t.Errorf("unexpected device type: %02X", data[0])
+	}
+	if data := flash.Read(SpiFlashSize-1, 2); data[0] != 0xFF || data[1] != 0xFF {
+		t.Errorf("unexpected out of range data: % X", data)
+	}
+
+	path := filepath.Join(t.TempDir(), "spi.bin")
+	if err := flash.Save(path); nil != err {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSpiFlash(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Read(ColorAddr, 6), flash.Read(ColorAddr, 6)) {
+		t.Error("loaded flash differs from saved one")
+	}
+}
+
+func TestImuData(t *testing.T) {
+	c := NewController(ProController)
+
+	data := c.ImuData()
+	if len(data) != 36 {
+		t.Fatalf("unexpected imu data length: %d", len(data))
+	}
+	// Resting: 1G on Z axis, at ±8G 4096 units per G
+	if data[4] != 0x00 || data[5] != 0x10 {
+		t.Errorf("unexpected resting accel Z: % X", data[4:6])
+	}
+
+	c.PushImuSamples(ImuSample{Gyro: Vector3{X: -70}})
+	data = c.ImuData()
+	if data[30] != 0x18 || data[31] != 0xFC {
+		t.Errorf("unexpected gyro X: % X", data[30:32])
+	}
+}
+
+func TestImuSensitivity(t *testing.T) {
+	c := NewController(ProController)
+
+	// ±4G and ±1000dps doubles the raw values of defaults
+	c.SetImuSensitivity([]byte{0x02, 0x01, 0x00, 0x00})
+	c.SetImu(ImuSample{Accel: Vector3{X: 1}})
+	data := c.ImuData()
+	if data[0] != 0x00 || data[1] != 0x20 {
+		t.Errorf("unexpected accel X: % X", data[0:2])
+	}
+	if regs := c.ReadImuRegisters(ImuRegCtrl1, 2); regs[0]&0x0C != 0x08 || regs[1]&0x0C != 0x08 {
+		t.Errorf("unexpected control registers: % X", regs)
+	}
+}
+
+func TestJoyConButtons(t *testing.T) {
+	c := NewController(JoyConL)
+
+	c.Press("SL", "A")
+	if b := c.Dump(); b[0] != 0x00 || b[2] != 0x20 {
+		t.Errorf("unexpected button data: % X", b)
+	}
+	c.SetStick(RightStick, StickMax, StickMax)
+	if _, right := c.StickState(); right != [3]byte{} {
+		t.Errorf("unexpected right stick data: % X", right)
+	}
+	c.Dump() // Clear Dirty
+	c.CenterStick(RightStick)
+	if c.Dirty {
+		t.Error("missing right stick centered")
+	}
+}
+
+func TestColors(t *testing.T) {
+	c := NewController(ProController)
+
+	body, err := ParseRGB("#FF0080")
+	if nil != err {
+		t.Fatal(err)
+	}
+	colors := DefaultColors[ProController]
+	colors.Body = body
+	if err := c.SetColors(colors); nil != err {
+		t.Fatal(err)
+	}
+	if data := c.Flash().Read(ColorAddr, 3); !bytes.Equal(data, []byte{0xFF, 0x00, 0x80}) {
+		t.Errorf("unexpected body color: % X", data)
+	}
+	if got := c.Colors(); got != colors {
+		t.Errorf("got colors %+v, expect %+v", got, colors)
+	}
+	if _, err := ParseRGB("#FF00"); nil == err {
+		t.Error("expect error for short color")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c := NewController(JoyConR)
+
+	if status := c.Status(); status != 0x8E {
+		t.Errorf("unexpected status: %02X", status)
+	}
+	c.SetBattery(BatteryLow, true)
+	c.SetGripConnected(true)
+	if status := c.Status(); status != 0x5F {
+		t.Errorf("unexpected status: %02X", status)
+	}
+	if b := c.Dump(); b[1]&0x80 == 0 {
+		t.Errorf("expect charging grip bit: % X", b)
+	}
+}
+
+func TestBatteryVoltage(t *testing.T) {
+	tests := []struct {
+		level    BatteryLevel
+		min, max uint16
+	}{
+		{BatteryEmpty, 0x000, 0x527},
+		{BatteryCritical, 0x528, 0x59F},
+		{BatteryLow, 0x5A0, 0x5DF},
+		{BatteryMedium, 0x5E0, 0x617},
+		{BatteryFull, 0x618, 0x690},
+	}
+	for _, test := range tests {
+		if v := test.level.Voltage(); v < test.min || v > test.max {
+			t.Errorf("%s: got %03X, expect %03X-%03X", test.level, v, test.min, test.max)
+		}
+	}
+}
+
+func TestPlayerLights(t *testing.T) {
+	c := NewController(ProController)
+
+	var notified PlayerLights
+	c.OnPlayerLights(func(l PlayerLights) { notified = l })
+	c.SetPlayerLights(0x0D)
+	if c.Player() != 7 || notified != 0x0D {
+		t.Errorf("unexpected player: %s", c.PlayerLights())
+	}
+	if lights := PlayerLights(0xF0); !lights.Searching() || lights.Player() != 0 {
+		t.Errorf("unexpected searching lights: %s", lights)
+	}
+}
+
+func TestParseHomeLight(t *testing.T) {
+	// 3 cycles of 8ms base, start off, repeat forever
+	light := ParseHomeLight([]byte{0x31, 0x00, 0xF0, 0x12, 0x34, 0x80, 0x56, 0x00})
+	t.Log(light)
+	if len(light.Cycles) != 3 || light.Off() {
+		t.Fatalf("unexpected cycles: %v", light.Cycles)
+	}
+	if c := light.Cycles[1]; c.Intensity != 0 || c.Fade != 24*time.Millisecond || c.Duration != 32*time.Millisecond {
+		t.Errorf("unexpected second cycle: %+v", c)
+	}
+	if c := light.Cycles[2]; c.Intensity != 8 || c.Fade != 40*time.Millisecond {
+		t.Errorf("unexpected third cycle: %+v", c)
+	}
+}
+
+func TestNfcTag(t *testing.T) {
+	c := NewController(ProController)
+
+	data := make([]byte, amiibo.TagSize)
+	copy(data, []byte{0x04, 0x11, 0x22, 0xBF, 0x33, 0x44, 0x55, 0x66})
+	data[amiibo.TagSize-1] = 0xAB
+	tag, err := amiibo.Parse(data)
+	if nil != err {
+		t.Fatal(err)
+	}
+	c.SetNfcTag(tag)
+	c.SetMcuState(McuNfc)
+	c.ToggleMcuPower(true)
+
+	c.HandleMcuRequest(R.RequestNfcDataReport, []byte{byte(NfcStartPolling)})
+	mcu := c.McuData()
+	if mcu[0] != 0x2A || mcu[7] != byte(NfcPolling) || !bytes.Equal(mcu[16:23], tag.UID()) {
+		t.Errorf("unexpected polling data: % X", mcu[:23])
+	}
+
+	c.HandleMcuRequest(R.RequestNfcDataReport, []byte{byte(NfcReadNtag)})
+	first, second := c.McuData(), c.McuData()
+	if first[0] != 0x3A || first[3] != 0x01 || second[3] != 0x02 {
+		t.Errorf("unexpected read packets: % X, % X", first[:8], second[:8])
+	}
+	if second[7+amiibo.TagSize-245-1] != 0xAB {
+		t.Error("tag data missing from second read packet")
+	}
+}
+
+func TestNfcWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "amiibo.bin")
+	data := make([]byte, amiibo.TagSize)
+	copy(data, []byte{0x04, 0x11, 0x22, 0xBF, 0x33, 0x44, 0x55, 0x66})
+	if err := os.WriteFile(path, data, 0644); nil != err {
+		t.Fatal(err)
+	}
+	tag, err := amiibo.Load(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	tag.SetBackups(1)
+
+	c := NewController(ProController)
+	c.SetNfcTag(tag)
+	c.SetMcuState(McuNfc)
+	c.ToggleMcuPower(true)
+
+	// Timeout, unknown, UID, unknown, 1 page, page 4 data
+	payload := append([]byte{0xD0, 0x07, 0x00, 0x07}, tag.UID()...)
+	payload = append(payload, 0x00, 0x00, 0x01, 0x04, 0xDE, 0xAD, 0xBE, 0xEF)
+	first := append([]byte{byte(NfcWriteNtag), 0x01, 0x00, 0x00, 5}, payload[:5]...)
+	last := append([]byte{byte(NfcWriteNtag), 0x02, 0x00, 0x08, byte(len(payload) - 5)}, payload[5:]...)
+	c.HandleMcuRequest(R.RequestNfcDataReport, first)
+	c.HandleMcuRequest(R.RequestNfcDataReport, last)
+
+	if mcu := c.McuData(); mcu[7] != byte(NfcWriteDone) {
+		t.Errorf("unexpected write reply: % X", mcu[:8])
+	}
+	if !bytes.Equal(tag.Data()[16:20], []byte{0xDE, 0xAD, 0xBE, 0xEF}) {
+		t.Errorf("page not written: % X", tag.Data()[16:20])
+	}
+	if saved, _ := os.ReadFile(path); !bytes.Equal(saved, tag.Data()) {
+		t.Error("dump not saved")
+	}
+	if backups, _ := filepath.Glob(path + ".*.bak"); len(backups) != 1 {
+		t.Errorf("unexpected backups: %v", backups)
+	}
+}
+
+func TestIrImageTransfer(t *testing.T) {
+	c := NewController(JoyConR)
+	frame := image.NewGray(image.Rect(0, 0, 320, 240))
+	for i := range frame.Pix {
+		frame.Pix[i] = byte(i / 320)
+	}
+	next := image.NewGray(image.Rect(0, 0, 40, 30))
+	for i := range next.Pix {
+		next.Pix[i] = 0xAA
+	}
+	c.SetIrFrames([]*image.Gray{frame, next})
+	c.SetMcuState(McuIr)
+	c.ToggleMcuPower(true)
+
+	// 40x30 resolution, then image transfer mode
+	if reply := c.ConfigureIr([]byte{0x04, 0x01, 0x00, 0x2E, 0x69}); reply[0] != 0x13 {
+		t.Errorf("unexpected registers reply: % X", reply)
+	}
+	if reply := c.ConfigureIr([]byte{0x01, byte(IrImageTransfer), 0x03}); reply[0] != 0x0B {
+		t.Errorf("unexpected mode reply: % X", reply)
+	}
+
+	for i := 0; i < 4; i++ {
+		mcu := c.McuData()
+		if mcu[0] != 0x03 || mcu[3] != byte(i) {
+			t.Fatalf("unexpected fragment header: % X", mcu[:4])
+		}
+		// Row y of the image shows source row y*8
+		if row := i * 300 / 40; mcu[10] != byte(row*8) {
+			t.Errorf("fragment %d: got pixel %d, expect %d", i, mcu[10], row*8)
+		}
+	}
+
+	// Fragments of the frame are resent until the last one is acknowledged
+	c.HandleMcuRequest(R.RequestIrDataReport, []byte{0x00, 0x01, 0x02})
+	if mcu := c.McuData(); mcu[3] != 0x02 || mcu[10] != 120 {
+		t.Errorf("missed fragment not resent: % X", mcu[:11])
+	}
+	if mcu := c.McuData(); mcu[3] != 0x03 {
+		t.Errorf("got fragment %d, expect the last one repeated", mcu[3])
+	}
+	c.HandleMcuRequest(R.RequestIrDataReport, []byte{0x00, 0x00, 0x03})
+	if mcu := c.McuData(); mcu[3] != 0x00 || mcu[10] != 0xAA {
+		t.Errorf("next frame not sent: % X", mcu[:11])
+	}
+}
+
+func TestIrCameraType(t *testing.T) {
+	c := NewController(ProController)
+	c.SetMcuState(McuIr)
+	if state := c.McuState(); state[7] != byte(McuStandby) {
+		t.Errorf("got MCU state %02X, expect standby without IR camera", state[7])
+	}
+
+	path := filepath.Join(t.TempDir(), "frame.raw")
+	if err := os.WriteFile(path, make([]byte, 100), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := LoadIrFrame(path); nil == err || !strings.Contains(err.Error(), "320x240, 160x120, 80x60, 40x30") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSimpleHidState(t *testing.T) {
+	c := NewController(ProController)
+	c.Press("A", "ZR", "Home", "UP", "RIGHT")
+	c.SetStick(LeftStick, StickMax, StickMax)
+
+	state := c.SimpleHidState()
+	if expect := uint16(1<<1 | 1<<7 | 1<<12); state.Buttons != expect {
+		t.Errorf("got buttons %016b, expect %016b", state.Buttons, expect)
+	}
+	if state.Hat != 1 {
+		t.Errorf("got hat %d, expect 1 (up-right)", state.Hat)
+	}
+	if expect := [4]uint16{0xFFFF, 0x0000, 0x8080, 0x7F7F}; state.Sticks != expect {
+		t.Errorf("got sticks %04X, expect %04X", state.Sticks, expect)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	c := NewController(ProController)
+	c.Mode = R.NfcMode
+	c.VibrationEnabled = true
+	c.SetPlayerLights(0x01)
+	c.SetMcuState(McuNfc)
+
+	c.Disconnect()
+	if c.Mode != 0 || c.VibrationEnabled || c.PlayerNumber {
+		t.Errorf("connection state kept: mode %02X, vibration %v, player %v", c.Mode, c.VibrationEnabled, c.PlayerNumber)
+	}
+	if state := c.McuState(); state[7] != byte(McuStandby) {
+		t.Errorf("got MCU state %02X, expect standby", state[7])
+	}
+}
+
+func TestInputState(t *testing.T) {
+	src, dst := NewController(ProController), NewController(ProController)
+	src.Press("A")
+	src.SetStick(LeftStick, StickMax, StickCenter)
+	src.SetBattery(BatteryLow, true)
+
+	dst.Dump() // Clear Dirty
+	dst.SetInputState(src.InputState())
+	if !dst.Dirty {
+		t.Error("changed input not marked dirty")
+	}
+	if !bytes.Equal(dst.Dump(), src.Dump()) || dst.Status() != src.Status() {
+		t.Errorf("got buttons % X status %02X, expect % X %02X", dst.Dump(), dst.Status(), src.Dump(), src.Status())
+	}
+	if left, _ := dst.StickState(); left != src.sticks[LeftStick].Bytes() {
+		t.Errorf("got left stick % X", left)
+	}
+
+	dst.SetInputState(src.InputState())
+	if dst.Dirty {
+		t.Error("same input marked dirty")
+	}
+
+	src.PushImuSamples(ImuSample{Gyro: Vector3{X: 90}})
+	dst.SetInputState(src.InputState())
+	if !dst.Dirty || !bytes.Equal(dst.ImuData(), src.ImuData()) {
+		t.Error("IMU samples not mirrored")
+	}
+}
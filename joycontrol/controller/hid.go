@@ -0,0 +1,91 @@
+package controller
+
+// SimpleHidState is the input carried by 0x3F reports, sent before
+// the host switches to the standard full mode.
+type SimpleHidState struct {
+	Buttons uint16
+	Hat     byte
+	Sticks  [4]uint16 // Left X, left Y, right X, right Y
+}
+
+const (
+	hatNeutral        byte   = 0x08
+	simpleStickCenter uint16 = 0x8000
+)
+
+// Simple HID button bits of the standard report buttons, SL and SR
+// act as L and R like a sideways Joy-Con.
+var simpleHidButtons = map[button]int{
+	{0, 2}: 0,  // B
+	{0, 3}: 1,  // A
+	{0, 0}: 2,  // Y
+	{0, 1}: 3,  // X
+	{2, 6}: 4,  // L
+	{0, 6}: 5,  // R
+	{2, 7}: 6,  // ZL
+	{0, 7}: 7,  // ZR
+	{1, 1}: 8,  // -
+	{1, 0}: 9,  // +
+	{1, 3}: 10, // LStick
+	{1, 2}: 11, // RStick
+	{1, 4}: 12, // Home
+	{1, 5}: 13, // Capture
+	{2, 5}: 4,  // SL (L)
+	{2, 4}: 5,  // SR (L)
+	{0, 5}: 4,  // SL (R)
+	{0, 4}: 5,  // SR (R)
+}
+
+// Hat switch value by vertical and horizontal direction, clockwise
+// from up.
+var simpleHidHat = [3][3]byte{
+	{7, 0, 1}, // Up
+	{6, hatNeutral, 2},
+	{5, 4, 3}, // Down
+}
+
+// SimpleHidState returns the buttons, D-pad and sticks in the 0x3F
+// layout, sticks have 8-bit precision.
+func (c *Controller) SimpleHidState() SimpleHidState {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	state := SimpleHidState{}
+	data := c.bs.data
+	for b, bit := range simpleHidButtons {
+		if (data[b.index]>>b.bit)&1 == 1 {
+			state.Buttons |= 1 << bit
+		}
+	}
+
+	vertical, horizontal := 1, 1
+	if (data[2]>>1)&1 == 1 { // UP
+		vertical--
+	}
+	if data[2]&1 == 1 { // DOWN
+		vertical++
+	}
+	if (data[2]>>3)&1 == 1 { // LEFT
+		horizontal--
+	}
+	if (data[2]>>2)&1 == 1 { // RIGHT
+		horizontal++
+	}
+	state.Hat = simpleHidHat[vertical][horizontal]
+
+	for i, stick := range []Stick{LeftStick, RightStick} {
+		state.Sticks[i*2], state.Sticks[i*2+1] = simpleStickCenter, simpleStickCenter
+		if !c.Type.HasStick(stick) {
+			continue
+		}
+		x, y := c.sticks[stick].Raw()
+		// HID axes grow downward, stick positions upward
+		state.Sticks[i*2] = simpleStickValue(byte(x >> 4))
+		state.Sticks[i*2+1] = simpleStickValue(0xFF - byte(y>>4))
+	}
+	return state
+}
+
+func simpleStickValue(v byte) uint16 {
+	return uint16(v)<<8 | uint16(v)
+}
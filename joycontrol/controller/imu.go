@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// https://github.com/dekuNukem/Nintendo_Switch_Reverse_Engineering/blob/master/imu_sensor_notes.md
+
+type Vector3 struct {
+	X, Y, Z float64
+}
+
+// ImuSample is a single motion reading, accelerometer in G and
+// gyroscope in degrees per second.
+type ImuSample struct {
+	Accel Vector3
+	Gyro  Vector3
+}
+
+// RestingImuSample is a controller laying flat on a table.
+var RestingImuSample = ImuSample{Accel: Vector3{Z: 1}}
+
+// Each standard report carries 3 samples taken 5ms apart, of 6
+// little-endian int16 values each.
+const (
+	imuSampleCount  = 3
+	imuSampleLength = 12
+)
+
+const (
+	defaultAccelRange = 8    // ±8G
+	defaultGyroRange  = 2000 // ±2000dps
+
+	// Raw units at default sensitivity, derived from the factory
+	// calibration coefficients 0x4000 and 0x343B.
+	accelUnitsPerG  = 4096
+	gyroUnitsPerDeg = 13371.0 / 936.0
+)
+
+// LSM6DS3 registers, the IMU used by Joy-Cons and Pro Controllers.
+const (
+	imuRegisterCount = 0x80
+
+	ImuRegWhoAmI byte = 0x0F
+	ImuRegCtrl1  byte = 0x10 // CTRL1_XL, accelerometer ODR and full scale
+	ImuRegCtrl2  byte = 0x11 // CTRL2_G, gyroscope ODR and full scale
+	ImuRegCtrl3  byte = 0x12 // CTRL3_C
+	ImuRegCtrl4  byte = 0x13 // CTRL4_C
+	ImuRegCtrl6  byte = 0x15 // CTRL6_C, accelerometer performance mode
+	ImuRegCtrl7  byte = 0x16 // CTRL7_G, gyroscope performance mode
+)
+
+// Full scale selection bits [3:2] of CTRL1_XL and CTRL2_G
+var (
+	accelFullScales = [4]float64{2, 16, 4, 8}
+	gyroFullScales  = [4]float64{250, 500, 1000, 2000}
+)
+
+// Sensitivity arguments of subcommand 0x41, indexed by their value
+var (
+	accelSensitivities = [4]byte{0b11, 0b10, 0b00, 0b01} // ±8G, ±4G, ±2G, ±16G
+	gyroSensitivities  = [4]byte{0b00, 0b01, 0b10, 0b11} // ±250, ±500, ±1000, ±2000dps
+	gyroRates          = [2]byte{0x07, 0x05}             // 833Hz, 208Hz
+)
+
+type Imu struct {
+	samples   [imuSampleCount]ImuSample
+	registers [imuRegisterCount]byte
+}
+
+func NewImu() *Imu {
+	m := &Imu{}
+	m.registers[ImuRegWhoAmI] = 0x69
+	m.registers[ImuRegCtrl1] = 0x60 | accelSensitivities[0]<<2 // 416Hz, ±8G
+	m.registers[ImuRegCtrl2] = 0x60 | gyroSensitivities[3]<<2  // 416Hz, ±2000dps
+	m.registers[ImuRegCtrl3] = 0x44                            // BDU, IF_INC
+	m.Set(RestingImuSample)
+	return m
+}
+
+// SetSensitivity applies subcommand 0x41 arguments: gyroscope
+// sensitivity, accelerometer sensitivity, gyroscope performance rate
+// and accelerometer anti-aliasing filter bandwidth.
+func (m *Imu) SetSensitivity(gyro, accel, gyroPerformance, accelFilter byte) {
+	m.registers[ImuRegCtrl2] = m.registers[ImuRegCtrl2]&^0x0C | gyroSensitivities[gyro&0x03]<<2
+	m.registers[ImuRegCtrl1] = m.registers[ImuRegCtrl1]&^0x0C | accelSensitivities[accel&0x03]<<2
+	m.registers[ImuRegCtrl2] = m.registers[ImuRegCtrl2]&^0xF0 | gyroRates[gyroPerformance&0x01]<<4
+	m.registers[ImuRegCtrl1] = m.registers[ImuRegCtrl1]&^0x03 | accelFilter&0x03
+}
+
+func (m *Imu) WriteRegister(addr, value byte) {
+	if int(addr) >= imuRegisterCount || addr == ImuRegWhoAmI {
+		return
+	}
+	m.registers[addr] = value
+}
+
+func (m *Imu) ReadRegisters(addr, count byte) []byte {
+	data := make([]byte, count)
+	for i := range data {
+		if pos := int(addr) + i; pos < imuRegisterCount {
+			data[i] = m.registers[pos]
+		}
+	}
+	return data
+}
+
+// Ranges returns the configured full scale in ±G and ±dps.
+func (m *Imu) Ranges() (accel, gyro float64) {
+	accel = accelFullScales[(m.registers[ImuRegCtrl1]>>2)&0x03]
+	gyro = gyroFullScales[(m.registers[ImuRegCtrl2]>>2)&0x03]
+	return
+}
+
+// Set reports the same reading for all samples of next reports.
+func (m *Imu) Set(sample ImuSample) {
+	for i := range m.samples {
+		m.samples[i] = sample
+	}
+}
+
+// Push appends readings, keeping the latest 3 as the samples of
+// next reports, oldest first.
+func (m *Imu) Push(samples ...ImuSample) {
+	for _, sample := range samples {
+		copy(m.samples[:], m.samples[1:])
+		m.samples[imuSampleCount-1] = sample
+	}
+}
+
+// Bytes encodes the samples scaled to the current sensitivity.
+func (m *Imu) Bytes() []byte {
+	data := make([]byte, imuSampleCount*imuSampleLength)
+	accelRange, gyroRange := m.Ranges()
+	accelScale := accelUnitsPerG * defaultAccelRange / accelRange
+	gyroScale := gyroUnitsPerDeg * defaultGyroRange / gyroRange
+	for i, sample := range m.samples {
+		values := []float64{
+			sample.Accel.X * accelScale, sample.Accel.Y * accelScale, sample.Accel.Z * accelScale,
+			sample.Gyro.X * gyroScale, sample.Gyro.Y * gyroScale, sample.Gyro.Z * gyroScale,
+		}
+		for j, v := range values {
+			offset := i*imuSampleLength + j*2
+			binary.LittleEndian.PutUint16(data[offset:], uint16(toInt16(v)))
+		}
+	}
+	return data
+}
+
+func toInt16(v float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v))))
+}
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// https://github.com/CTCaer/Nintendo_Switch_Reverse_Engineering/blob/ir-nfc/mcu_ir_nfc_notes.md
+
+type IrMode uint8
+
+const (
+	IrNone          IrMode = 0x00
+	IrMoment        IrMode = 0x03
+	IrClustering    IrMode = 0x06
+	IrImageTransfer IrMode = 0x07
+)
+
+// IR camera registers, keyed by page and address.
+const (
+	irRegResolution uint16 = 0x002E // Page 0, binning and skipping
+)
+
+// Resolutions selected by register 0x2E of page 0.
+var irResolutions = map[byte]image.Point{
+	0x00: {320, 240},
+	0x50: {160, 120},
+	0x64: {80, 60},
+	0x69: {40, 30},
+}
+
+// Images are sent in fragments of 300 pixels, the payload header
+// repeats the report id and fragment number.
+const (
+	irFragmentLength = 300
+	irFragmentOffset = 10
+)
+
+// IrCamera emulates the MCU IR mode of the Joy-Con (R), streaming
+// frames with image transfer.
+type IrCamera struct {
+	mode      IrMode
+	registers map[uint16]byte
+
+	frames   []*image.Gray
+	frame    int
+	image    []byte // Current frame at the configured resolution
+	fragment int    // Next fragment, the last one repeats until acknowledged
+	resend   int    // Missed fragment asked by the console, -1 if none
+}
+
+func NewIrCamera() *IrCamera {
+	c := &IrCamera{resend: -1}
+	c.Reset()
+	return c
+}
+
+// Reset returns to the state of the camera after entering IR mode.
+func (c *IrCamera) Reset() {
+	c.mode = IrNone
+	c.registers = map[uint16]byte{irRegResolution: 0x69}
+	c.image = nil
+	c.fragment = 0
+	c.resend = -1
+}
+
+// SetFrames replaces the frames shown to the camera, they are sent
+// in turn, one per complete image transfer.
+func (c *IrCamera) SetFrames(frames []*image.Gray) {
+	c.frames = frames
+	c.frame = 0
+	c.image = nil
+	c.fragment = 0
+}
+
+// Configure applies the IR mode of MCU command 0x23: subcommand 0x01
+// sets the mode, the last fragment number it also carries follows the
+// resolution. 0x04 writes up to 9 camera registers as page, address
+// and value.
+func (c *IrCamera) Configure(args []byte) []byte {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case 0x01:
+		if len(args) < 2 {
+			return nil
+		}
+		c.mode = IrMode(args[1])
+		c.image = nil
+		c.fragment = 0
+		return []byte{0x0B}
+	case 0x04:
+		if len(args) < 2 {
+			return nil
+		}
+		registers := args[2:]
+		for i := 0; i < int(args[1]) && (i+1)*3 <= len(registers); i++ {
+			entry := registers[i*3 : (i+1)*3]
+			c.registers[uint16(entry[0])<<8|uint16(entry[1])] = entry[2]
+		}
+		c.image = nil
+		c.fragment = 0
+	}
+	return c.StatusData()
+}
+
+// Resolution returns the image size set by the console, 40x30 when
+// the register holds an unknown value.
+func (c *IrCamera) Resolution() image.Point {
+	if size, ok := irResolutions[c.registers[irRegResolution]]; ok {
+		return size
+	}
+	return irResolutions[0x69]
+}
+
+func (c *IrCamera) StatusData() []byte {
+	return []byte{0x13, 0x00, byte(c.mode)}
+}
+
+// HandleRequest processes a RequestIrDataReport: 0x00 acknowledges
+// the fragment in byte 2, with byte 1 set the console asks for it
+// again instead. 0x02 requests the status.
+func (c *IrCamera) HandleRequest(args []byte) []byte {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case 0x00:
+		if len(args) < 3 {
+			break
+		}
+		if args[1] == 0x01 {
+			c.resend = int(args[2])
+		} else if c.image != nil && int(args[2]) == c.lastFragment() && c.fragment == c.lastFragment() {
+			c.nextFrame()
+		}
+	case 0x02:
+		return c.StatusData()
+	}
+	return nil
+}
+
+// Streaming tells if Data returns image fragments.
+func (c *IrCamera) Streaming() bool {
+	return c.mode == IrImageTransfer
+}
+
+// Data returns the next image fragment. The frame is kept for resends
+// until the console acknowledges its last fragment, which is repeated
+// meanwhile.
+func (c *IrCamera) Data() []byte {
+	if c.image == nil {
+		c.image = c.render()
+	}
+
+	fragment := c.fragment
+	if c.resend >= 0 && c.resend <= c.lastFragment() {
+		fragment, c.resend = c.resend, -1
+	} else if c.fragment < c.lastFragment() {
+		c.fragment++
+	}
+
+	data := make([]byte, irFragmentOffset+irFragmentLength)
+	data[0] = 0x03 // IR data report
+	data[1] = 0x00
+	data[2] = 0x00
+	data[3] = byte(fragment)
+	copy(data[irFragmentOffset:], c.image[fragment*irFragmentLength:])
+	return data
+}
+
+func (c *IrCamera) lastFragment() int {
+	return len(c.image)/irFragmentLength - 1
+}
+
+func (c *IrCamera) nextFrame() {
+	c.image = nil
+	c.fragment = 0
+	c.resend = -1
+	if len(c.frames) > 0 {
+		c.frame = (c.frame + 1) % len(c.frames)
+	}
+}
+
+// render scales the current frame to the configured resolution,
+// without frames the camera sees nothing.
+func (c *IrCamera) render() []byte {
+	size := c.Resolution()
+	data := make([]byte, size.X*size.Y)
+	if len(c.frames) == 0 {
+		return data
+	}
+
+	frame := c.frames[c.frame]
+	bounds := frame.Bounds()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			fx := bounds.Min.X + x*bounds.Dx()/size.X
+			fy := bounds.Min.Y + y*bounds.Dy()/size.Y
+			data[y*size.X+x] = frame.GrayAt(fx, fy).Y
+		}
+	}
+	return data
+}
+
+// LoadIrFrame reads a frame for the IR camera, a PNG image or raw 8
+// bits grayscale pixels of one of the camera resolutions.
+func LoadIrFrame(path string) (*image.Gray, error) {
+	if strings.EqualFold(filepath.Ext(path), ".png") {
+		return loadPngFrame(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	resolutions := make([]image.Point, 0, len(irResolutions))
+	for _, size := range irResolutions {
+		resolutions = append(resolutions, size)
+	}
+	sort.Slice(resolutions, func(i, j int) bool {
+		return resolutions[i].X > resolutions[j].X
+	})
+
+	sizes := make([]string, len(resolutions))
+	for i, size := range resolutions {
+		if len(data) == size.X*size.Y {
+			return &image.Gray{Pix: data, Stride: size.X, Rect: image.Rect(0, 0, size.X, size.Y)}, nil
+		}
+		sizes[i] = fmt.Sprintf("%dx%d", size.X, size.Y)
+	}
+	return nil, fmt.Errorf("bad ir frame size %d, expect 8 bits grayscale of %s", len(data), strings.Join(sizes, ", "))
+}
+
+func loadPngFrame(path string) (*image.Gray, error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if nil != err {
+		return nil, err
+	}
+	if gray, ok := img.(*image.Gray); ok {
+		return gray, nil
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x, y, color.GrayModel.Convert(img.At(x, y)))
+		}
+	}
+	return gray, nil
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PlayerLights is the argument of subcommand 0x30, the low nibble
+// turns LEDs on and the high nibble makes them flash, bit 0 being
+// the first LED.
+type PlayerLights byte
+
+// LED patterns the Switch uses for player slots, by player number.
+var playerPatterns = [8]byte{0b0001, 0b0011, 0b0111, 0b1111, 0b1001, 0b0101, 0b1101, 0b0110}
+
+func (l PlayerLights) On() byte {
+	return byte(l) & 0x0F
+}
+
+func (l PlayerLights) Flashing() byte {
+	return byte(l) >> 4
+}
+
+// Player returns the player number 1-8 shown by the LEDs, or 0 when
+// they don't show a player slot, e.g. while searching.
+func (l PlayerLights) Player() int {
+	for i, p := range playerPatterns {
+		if p == l.On() {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Searching tells if the LEDs only flash, as when the console looks
+// for controllers.
+func (l PlayerLights) Searching() bool {
+	return l.On() == 0 && l.Flashing() != 0
+}
+
+func (l PlayerLights) String() string {
+	state := "on"
+	if l.Searching() {
+		state = "searching"
+	}
+	return fmt.Sprintf("Player %d %s (%04b/%04b)", l.Player(), state, l.On(), l.Flashing())
+}
+
+// HomeLightCycle is one step of the HOME LED pattern, fading to the
+// intensity 0-15 and then holding it.
+type HomeLightCycle struct {
+	Intensity byte
+	Fade      time.Duration
+	Duration  time.Duration
+}
+
+// HomeLight is the HOME LED pattern set with subcommand 0x38.
+type HomeLight struct {
+	BaseDuration   time.Duration
+	StartIntensity byte
+	Repeat         byte // Full cycles, 0 repeats forever
+	Cycles         []HomeLightCycle
+}
+
+// ParseHomeLight reads subcommand 0x38 arguments, mini cycles are
+// packed by pairs in 3 bytes: both intensities, then fading and
+// duration multipliers of each.
+func ParseHomeLight(args []byte) HomeLight {
+	light := HomeLight{
+		BaseDuration:   homeLightBaseDuration(args[0] & 0x0F),
+		StartIntensity: args[1] >> 4,
+		Repeat:         args[1] & 0x0F,
+	}
+
+	count := int(args[0] >> 4)
+	for i := 0; i < count; i++ {
+		pair := 2 + (i/2)*3
+		if pair+1+i%2 >= len(args) {
+			break
+		}
+		intensity := args[pair] >> 4
+		if i%2 == 1 {
+			intensity = args[pair] & 0x0F
+		}
+		multipliers := args[pair+1+i%2]
+		light.Cycles = append(light.Cycles, HomeLightCycle{
+			Intensity: intensity,
+			Fade:      light.BaseDuration * time.Duration(multipliers>>4),
+			Duration:  light.BaseDuration * time.Duration(multipliers&0x0F),
+		})
+	}
+	return light
+}
+
+// Off tells if the pattern never lights the LED.
+func (h HomeLight) Off() bool {
+	if h.StartIntensity != 0 {
+		return false
+	}
+	for _, cycle := range h.Cycles {
+		if cycle.Intensity != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (h HomeLight) String() string {
+	if h.Off() {
+		return "HOME light off"
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("HOME light start %d, repeat %d", h.StartIntensity, h.Repeat))
+	for _, cycle := range h.Cycles {
+		builder.WriteString(fmt.Sprintf(", %d fade %v hold %v", cycle.Intensity, cycle.Fade, cycle.Duration))
+	}
+	return builder.String()
+}
+
+// homeLightBaseDuration maps the nibble to 8ms-175ms, 0 is off.
+func homeLightBaseDuration(n byte) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return 8*time.Millisecond + time.Duration(n-1)*167*time.Millisecond/14
+}
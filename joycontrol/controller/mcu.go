@@ -1,10 +1,21 @@
 package controller
 
+import (
+	"bytes"
+
+	"dio.wtf/joycontrol/joycontrol/amiibo"
+	"dio.wtf/joycontrol/joycontrol/log"
+	R "dio.wtf/joycontrol/joycontrol/report"
+)
+
+// https://github.com/CTCaer/Nintendo_Switch_Reverse_Engineering/blob/ir-nfc/mcu_ir_nfc_notes.md
+
 type McuMode uint8
 
 const (
 	McuStandby McuMode = 0x01
 	McuNfc     McuMode = 0x04
+	McuIr      McuMode = 0x05
 	McuBusy    McuMode = 0x06
 )
 
@@ -15,13 +26,51 @@ const (
 	McuResume  McuPowerState = 0x01
 )
 
+type NfcState uint8
+
+const (
+	NfcNone      NfcState = 0x00
+	NfcPolling   NfcState = 0x01
+	NfcReading   NfcState = 0x02
+	NfcWriteDone NfcState = 0x05
+)
+
+type NfcCommand uint8
+
+const (
+	NfcCancel       NfcCommand = 0x00
+	NfcStartPolling NfcCommand = 0x01
+	NfcStopPolling  NfcCommand = 0x02
+	NfcStartWaiting NfcCommand = 0x04
+	NfcReadNtag     NfcCommand = 0x06
+	NfcWriteNtag    NfcCommand = 0x08
+)
+
+// McuDataLength is the MCU payload of a 0x31 report, the last byte
+// is the CRC8 of the preceding ones.
+const McuDataLength = 313
+
+// NTAG215 memory is sent in two packets when read.
+const ntagFirstPacketLength = 245
+
 type MicroControllerUnit struct {
 	mode       McuMode
 	powerState McuPowerState
+
+	nfcState NfcState
+	tag      *amiibo.Tag
+	pending  [][]byte
+	fragment []byte // Payload of a multi packets NFC command
+
+	ir *IrCamera
 }
 
 func (m *MicroControllerUnit) SetState(state McuMode) {
 	m.mode = state
+	m.nfcState = NfcNone
+	m.pending = nil
+	m.fragment = nil
+	m.ir.Reset()
 }
 
 func (m *MicroControllerUnit) TogglePowerState(on bool) {
@@ -32,6 +81,10 @@ func (m *MicroControllerUnit) TogglePowerState(on bool) {
 	}
 }
 
+func (m *MicroControllerUnit) SetTag(tag *amiibo.Tag) {
+	m.tag = tag
+}
+
 func (m *MicroControllerUnit) StateData() []byte {
 	data := make([]byte, 8)
 	data[0] = 0x01                // mcu input report id
@@ -42,3 +95,167 @@ func (m *MicroControllerUnit) StateData() []byte {
 	data[7] = byte(m.mode)        // MCU State
 	return data
 }
+
+// HandleRequest processes a RequestNfcData output report, replies
+// are queued and sent by the following 0x31 reports.
+func (m *MicroControllerUnit) HandleRequest(cmd R.McuCommand, args []byte) {
+	switch cmd {
+	case R.RequestMcuStatus:
+		m.pending = append(m.pending, m.StateData())
+	case R.RequestNfcDataReport:
+		m.handleNfcCommand(NfcCommand(args[0]), args[1:])
+	case R.RequestIrDataReport:
+		if m.mode != McuIr {
+			log.Debug("IR request outside of IR mode")
+			break
+		}
+		if reply := m.ir.HandleRequest(args); reply != nil {
+			m.pending = append(m.pending, reply)
+		}
+	default:
+		log.DebugF("Unknown MCU request: %s", cmd)
+	}
+}
+
+func (m *MicroControllerUnit) handleNfcCommand(cmd NfcCommand, args []byte) {
+	if m.mode != McuNfc {
+		log.DebugF("NFC command %02x outside of NFC mode", cmd)
+		return
+	}
+
+	switch cmd {
+	case NfcCancel, NfcStopPolling:
+		m.nfcState = NfcNone
+	case NfcStartPolling, NfcStartWaiting:
+		m.nfcState = NfcPolling
+	case NfcReadNtag:
+		if m.tag == nil {
+			break
+		}
+		// Keep reporting the tag once both packets are sent
+		m.pending = append(m.pending, m.ntagReadData()...)
+		m.nfcState = NfcPolling
+		return
+	case NfcWriteNtag:
+		payload, complete := m.collectFragment(args)
+		if !complete {
+			return
+		}
+		if m.tag != nil && m.writeNtag(payload) {
+			m.nfcState = NfcWriteDone
+			m.pending = append(m.pending, m.nfcStateData())
+			m.nfcState = NfcPolling
+			return
+		}
+	default:
+		log.DebugF("Unknown NFC command: %02x", cmd)
+	}
+	m.pending = append(m.pending, m.nfcStateData())
+}
+
+// collectFragment joins NFC command packets: sequence number, unknown
+// byte, 0x08 on the last packet, payload length then payload.
+func (m *MicroControllerUnit) collectFragment(args []byte) (payload []byte, complete bool) {
+	if len(args) < 4 {
+		return nil, false
+	}
+	if args[0] <= 1 {
+		m.fragment = nil
+	}
+	length := int(args[3])
+	if length > len(args)-4 {
+		length = len(args) - 4
+	}
+	m.fragment = append(m.fragment, args[4:4+length]...)
+	if args[2] != 0x08 {
+		return nil, false
+	}
+	payload, m.fragment = m.fragment, nil
+	return payload, true
+}
+
+// writeNtag applies an NTAG write payload: 2 bytes timeout, unknown
+// byte, UID length and UID of the target tag, 2 unknown bytes, page
+// count then page number and 4 bytes of data for each page.
+func (m *MicroControllerUnit) writeNtag(payload []byte) bool {
+	if len(payload) < 4 || len(payload) < 4+int(payload[3])+3 {
+		log.Error("NTAG write payload too short")
+		return false
+	}
+	uidLength := int(payload[3])
+	uid := payload[4 : 4+uidLength]
+	if !bytes.Equal(uid, m.tag.UID()) {
+		log.ErrorF("NTAG write to another tag: % X", uid)
+		return false
+	}
+
+	pages := payload[4+uidLength+3:]
+	count := int(payload[4+uidLength+2])
+	for i := 0; i < count && (i+1)*5 <= len(pages); i++ {
+		entry := pages[i*5 : (i+1)*5]
+		if err := m.tag.Write(int(entry[0]), entry[1:]); nil != err {
+			log.ErrorF("NTAG write page %d: %v", entry[0], err)
+			return false
+		}
+	}
+
+	if err := m.tag.Save(); nil != err {
+		log.ErrorF("save amiibo %s: %v", m.tag.Path(), err)
+	}
+	return true
+}
+
+// ConfigureIr applies MCU command 0x23 and returns the data of the
+// subcommand reply.
+func (m *MicroControllerUnit) ConfigureIr(args []byte) []byte {
+	if m.mode != McuIr {
+		log.Debug("IR configuration outside of IR mode")
+		return m.StateData()
+	}
+	return m.ir.Configure(args)
+}
+
+// Data returns the MCU payload of the next 0x31 report, without the
+// trailing CRC8.
+func (m *MicroControllerUnit) Data() []byte {
+	data := make([]byte, McuDataLength-1)
+	switch {
+	case len(m.pending) > 0:
+		copy(data, m.pending[0])
+		m.pending = m.pending[1:]
+	case m.mode == McuNfc && m.powerState == McuResume:
+		copy(data, m.nfcStateData())
+	case m.mode == McuIr && m.powerState == McuResume && m.ir.Streaming():
+		copy(data, m.ir.Data())
+	default:
+		data[0] = 0xFF // No data
+	}
+	return data
+}
+
+func (m *MicroControllerUnit) nfcStateData() []byte {
+	data := []byte{0x2A, 0x00, 0x05, 0x00, 0x00, 0x09, 0x31, byte(m.nfcState)}
+	if m.tag != nil && m.nfcState != NfcNone {
+		// Tag detected: NFC-A NTAG with a 7 bytes UID
+		data = append(data, 0x00, 0x00, 0x00, 0x01, 0x01, 0x02, 0x00, amiibo.UidLength)
+		data = append(data, m.tag.UID()...)
+	}
+	return data
+}
+
+func (m *MicroControllerUnit) ntagReadData() [][]byte {
+	tag := m.tag.Data()
+
+	first := []byte{0x3A, 0x00, 0x07, 0x01, 0x00, 0x01, 0x31, byte(NfcReading), 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, amiibo.UidLength}
+	first = append(first, m.tag.UID()...)
+	first = append(first,
+		0x00, 0x00, 0x00, 0x00, 0x7D, 0xFD, 0xF0, 0x79, 0x36, 0x51, 0xAB, 0xD7, 0x46, 0x6E, 0x39,
+		0xC1, 0x91, 0xBA, 0xBE, 0xB8, 0x56, 0xCE, 0xED, 0xF1, 0xCE, 0x44, 0xCC, 0x75, 0xEA, 0xFB,
+		0x27, 0x09, 0x4D, 0x08, 0x7A, 0xE8, 0x03, 0x00, 0x3B, 0x3C, 0x77, 0x78, 0x86, 0x00, 0x00,
+	)
+	first = append(first, tag[:ntagFirstPacketLength]...)
+
+	second := []byte{0x3A, 0x00, 0x07, 0x02, 0x00, 0x09, 0x27}
+	second = append(second, tag[ntagFirstPacketLength:]...)
+	return [][]byte{first, second}
+}
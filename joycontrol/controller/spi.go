@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"dio.wtf/joycontrol/joycontrol/fileutil"
+)
+
+// https://github.com/dekuNukem/Nintendo_Switch_Reverse_Engineering/blob/master/spi_flash_notes.md
+
+const (
+	SpiFlashSize  = 0x80000
+	SpiSectorSize = 0x1000
+)
+
+const (
+	PairingInfoAddr             uint32 = 0x2000
+	SerialNumberAddr            uint32 = 0x6000
+	DeviceTypeAddr              uint32 = 0x6012
+	ColorInfoAddr               uint32 = 0x601B
+	FactoryImuCalibrationAddr   uint32 = 0x6020
+	FactoryStickCalibrationAddr uint32 = 0x603D
+	ColorAddr                   uint32 = 0x6050
+	ImuHorizontalOffsetsAddr    uint32 = 0x6080
+	StickParameters1Addr        uint32 = 0x6086
+	StickParameters2Addr        uint32 = 0x6098
+	UserStickCalibrationAddr    uint32 = 0x8010
+	UserImuCalibrationAddr      uint32 = 0x8026
+)
+
+var ErrSpiOutOfRange = errors.New("spi flash address out of range")
+
+// SpiFlash emulates the 512KB SPI flash of a controller, blank
+// areas are filled with 0xFF like on real hardware.
+type SpiFlash struct {
+	mux  sync.RWMutex
+	data []byte
+	path string
+}
+
+// NewSpiFlash returns a flash image with factory defaults of the
+// controller type and no user calibration.
+func NewSpiFlash(t ControllerType) *SpiFlash {
+	f := &SpiFlash{data: make([]byte, SpiFlashSize)}
+	for i := range f.data {
+		f.data[i] = 0xFF
+	}
+
+	// Serial number, 0xFF means no serial
+	f.write(DeviceTypeAddr, []byte{byte(t)})
+	f.write(FactoryImuCalibrationAddr, []byte{
+		0xD3, 0xFF, 0xD5, 0xFF, 0x55, 0x01, // Acc XYZ origin position when completely horizontal
+		0x00, 0x40, 0x00, 0x40, 0x00, 0x40, // Acc XYZ sensitivity special coeff, for default sensitivity: ±8G
+		0x19, 0x00, 0xDD, 0xFF, 0xDC, 0xFF, // Gyro XYZ origin position when still
+		0x3B, 0x34, 0x3B, 0x34, 0x3B, 0x34, // Gyro XYZ sensitivity special coeff, for default sensitivity: ±2000dps
+	})
+	if t.HasStick(LeftStick) {
+		// Max above center, center, min below center
+		f.write(FactoryStickCalibrationAddr, []byte{0xBA, 0xF5, 0x62, 0x6F, 0xC8, 0x77, 0xED, 0x95, 0x5B})
+	}
+	if t.HasStick(RightStick) {
+		// Center, min below center, max above center
+		f.write(FactoryStickCalibrationAddr+9, []byte{0x16, 0xD8, 0x7D, 0xF2, 0xB5, 0x5F, 0x86, 0x65, 0x5E})
+	}
+	f.writeColors(t, DefaultColors[t])
+	f.write(ImuHorizontalOffsetsAddr, []byte{0x50, 0xFD, 0x00, 0x00, 0xC6, 0x0F})
+	stickParameters := []byte{
+		0x0F, 0x30, 0x61, 0xAE, 0x90, 0xD9, 0xD4, 0x14, 0x54,
+		0x41, 0x15, 0x54, 0xC7, 0x79, 0x9C, 0x33, 0x36, 0x63,
+	}
+	f.write(StickParameters1Addr, stickParameters)
+	f.write(StickParameters2Addr, stickParameters)
+	return f
+}
+
+// OpenSpiFlash loads the flash image at path, or creates one with
+// defaults of the controller type when missing. Writes and erases
+// are saved back to path.
+func OpenSpiFlash(path string, t ControllerType) (*SpiFlash, error) {
+	f, err := LoadSpiFlash(path)
+	if errors.Is(err, os.ErrNotExist) {
+		f = NewSpiFlash(t)
+		err = f.Save(path)
+	}
+	if nil != err {
+		return nil, err
+	}
+	f.path = path
+	return f, nil
+}
+
+// LoadSpiFlash reads a flash image from a dump of a real controller.
+func LoadSpiFlash(path string) (*SpiFlash, error) {
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	if len(data) != SpiFlashSize {
+		return nil, fmt.Errorf("bad spi flash dump size %d, expect %d", len(data), SpiFlashSize)
+	}
+	return &SpiFlash{data: data}, nil
+}
+
+func (f *SpiFlash) Save(path string) error {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return fileutil.WriteFile(path, f.data)
+}
+
+// Read returns a copy of size bytes starting at addr, out of range
+// bytes are read as 0xFF.
+func (f *SpiFlash) Read(addr uint32, size int) []byte {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	data := make([]byte, size)
+	for i := range data {
+		if pos := int(addr) + i; pos < len(f.data) {
+			data[i] = f.data[pos]
+		} else {
+			data[i] = 0xFF
+		}
+	}
+	return data
+}
+
+// Write stores data at addr, and persists the image if it was
+// opened by OpenSpiFlash.
+func (f *SpiFlash) Write(addr uint32, data []byte) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if int(addr)+len(data) > len(f.data) {
+		return ErrSpiOutOfRange
+	}
+	f.write(addr, data)
+	return f.persist()
+}
+
+// EraseSector resets the 4KB sector containing addr to 0xFF, and
+// persists the image if it was opened by OpenSpiFlash.
+func (f *SpiFlash) EraseSector(addr uint32) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if int(addr) >= len(f.data) {
+		return ErrSpiOutOfRange
+	}
+	start := addr &^ (SpiSectorSize - 1)
+	for i := start; i < start+SpiSectorSize; i++ {
+		f.data[i] = 0xFF
+	}
+	return f.persist()
+}
+
+// WriteColors stores body, buttons and grip colors, and persists the
+// image if it was opened by OpenSpiFlash.
+func (f *SpiFlash) WriteColors(t ControllerType, colors Colors) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.writeColors(t, colors)
+	return f.persist()
+}
+
+// Colors returns the colors currently stored in the image.
+func (f *SpiFlash) Colors() Colors {
+	var colors Colors
+	data := f.Read(ColorAddr, 12)
+	copy(colors.Body[:], data[0:3])
+	copy(colors.Buttons[:], data[3:6])
+	copy(colors.LeftGrip[:], data[6:9])
+	copy(colors.RightGrip[:], data[9:12])
+	return colors
+}
+
+func (f *SpiFlash) writeColors(t ControllerType, colors Colors) {
+	// 0x01 body and buttons colors, 0x02 also grips colors
+	info := byte(0x01)
+	if t == ProController {
+		info = 0x02
+	}
+	f.write(ColorInfoAddr, []byte{info})
+	f.write(ColorAddr, colors.Bytes())
+}
+
+func (f *SpiFlash) persist() error {
+	if f.path == "" {
+		return nil
+	}
+	return fileutil.WriteFile(f.path, f.data)
+}
+
+func (f *SpiFlash) write(addr uint32, data []byte) {
+	if int(addr) >= len(f.data) {
+		return
+	}
+	copy(f.data[addr:], data)
+}
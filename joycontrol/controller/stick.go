@@ -0,0 +1,99 @@
+package controller
+
+import "math"
+
+// https://github.com/dekuNukem/Nintendo_Switch_Reverse_Engineering/blob/master/bluetooth_hid_notes.md#standard-input-report---stick-data
+
+type Stick uint8
+
+const (
+	LeftStick Stick = iota
+	RightStick
+)
+
+func (s Stick) String() string {
+	switch s {
+	case LeftStick:
+		return "LeftStick"
+	case RightStick:
+		return "RightStick"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func (s Stick) valid() bool {
+	return s <= RightStick
+}
+
+// Raw stick positions are 12-bit values per axis.
+const (
+	StickMin    uint16 = 0x000
+	StickCenter uint16 = 0x800
+	StickMax    uint16 = 0xFFF
+)
+
+type StickState struct {
+	x uint16
+	y uint16
+}
+
+func NewStickState() *StickState {
+	return &StickState{x: StickCenter, y: StickCenter}
+}
+
+// Set stores raw 12-bit horizontal and vertical positions, values
+// above StickMax are clamped.
+func (s *StickState) Set(x, y uint16) {
+	s.x = clampStick(x)
+	s.y = clampStick(y)
+}
+
+// SetNormalized stores positions in range [-1, 1], where 0 is the
+// center, -1 is left/down and 1 is right/up.
+func (s *StickState) SetNormalized(x, y float64) {
+	s.x = normalizedToRaw(x)
+	s.y = normalizedToRaw(y)
+}
+
+// SetPolar stores a position given by an angle in degrees, counter
+// clockwise starting from the right, and a magnitude in range [0, 1].
+func (s *StickState) SetPolar(angle, magnitude float64) {
+	rad := angle * math.Pi / 180
+	magnitude = math.Max(0, math.Min(1, magnitude))
+	s.SetNormalized(magnitude*math.Cos(rad), magnitude*math.Sin(rad))
+}
+
+func (s *StickState) Center() {
+	s.x = StickCenter
+	s.y = StickCenter
+}
+
+func (s *StickState) Raw() (x, y uint16) {
+	return s.x, s.y
+}
+
+// Bytes packs both 12-bit axes into the 3 bytes layout used by
+// input reports.
+func (s *StickState) Bytes() [3]byte {
+	return [3]byte{
+		byte(s.x & 0xFF),
+		byte((s.x >> 8) | ((s.y & 0x0F) << 4)),
+		byte(s.y >> 4),
+	}
+}
+
+func clampStick(v uint16) uint16 {
+	if v > StickMax {
+		return StickMax
+	}
+	return v
+}
+
+func normalizedToRaw(v float64) uint16 {
+	v = math.Max(-1, math.Min(1, v))
+	if v < 0 {
+		return uint16(math.Round(float64(StickCenter) * (1 + v)))
+	}
+	return uint16(math.Round(float64(StickCenter) + float64(StickMax-StickCenter)*v))
+}
@@ -0,0 +1,203 @@
+package controller
+
+// ControllerType is the device type reported to the Switch with
+// device info and stored at 0x6012 of the SPI flash.
+type ControllerType uint8
+
+const (
+	JoyConL       ControllerType = 0x01
+	JoyConR       ControllerType = 0x02
+	ProController ControllerType = 0x03
+
+	// Nintendo Switch Online classic controllers
+	NesL    ControllerType = 0x09
+	NesR    ControllerType = 0x0A
+	Snes    ControllerType = 0x0B
+	N64     ControllerType = 0x0C
+	Genesis ControllerType = 0x0D
+)
+
+// String returns the name the controller advertises over Bluetooth.
+func (t ControllerType) String() string {
+	switch t {
+	case JoyConL:
+		return "Joy-Con (L)"
+	case JoyConR:
+		return "Joy-Con (R)"
+	case ProController:
+		return "Pro Controller"
+	case NesL, NesR:
+		return "NES Controller"
+	case Snes:
+		return "SNES Controller"
+	case N64:
+		return "N64 Controller"
+	case Genesis:
+		return "MD/Gen Control Pad"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ConnectionInfo is the low nibble of the battery and connection
+// byte in standard reports, bits 1-2 are 3 for Joy-Cons and 0 for
+// Pro Controller.
+func (t ControllerType) ConnectionInfo() byte {
+	switch t {
+	case JoyConL, JoyConR, NesL, NesR:
+		return 0x0E
+	default:
+		return 0x00
+	}
+}
+
+// HasStick tells if the type has the given physical stick.
+func (t ControllerType) HasStick(stick Stick) bool {
+	switch t {
+	case JoyConL, N64:
+		return stick == LeftStick
+	case JoyConR:
+		return stick == RightStick
+	case NesL, NesR, Snes, Genesis:
+		return false
+	default:
+		return true
+	}
+}
+
+// HasIrCamera tells if the type has the IR camera of the MCU, only
+// the Joy-Con (R) has one.
+func (t ControllerType) HasIrCamera() bool {
+	return t == JoyConR
+}
+
+type button struct {
+	index int
+	bit   int
+}
+
+// | Byte       | x01 | x02 | x04    | x08    | x10 | x20    | x40 | x80         |
+// |:----------:|:---:|:---:|:------:|:------:|:---:|:------:|:---:|:-----------:|
+// | 3 (Right)  | Y   | X   | B      | A      | SR  | SL     | R   | ZR          |
+// | 4 (Shared) | -   | +   | R Stick| L Stick| Home| Capture| --  |Charging Grip|
+// | 5 (Left)   | Down| Up  | Right  | Left   | SR  | SL     | L   | ZL          |
+var buttonMaps = map[ControllerType]map[string]button{
+	ProController: {
+		"Y":            {0, 0},
+		"X":            {0, 1},
+		"B":            {0, 2},
+		"A":            {0, 3},
+		"R":            {0, 6},
+		"ZR":           {0, 7},
+		"+":            {1, 0},
+		"-":            {1, 1},
+		"RStick":       {1, 2},
+		"LStick":       {1, 3},
+		"Home":         {1, 4},
+		"Capture":      {1, 5},
+		"ChargingGrip": {1, 7},
+		"DOWN":         {2, 0},
+		"UP":           {2, 1},
+		"RIGHT":        {2, 2},
+		"LEFT":         {2, 3},
+		"L":            {2, 6},
+		"ZL":           {2, 7},
+	},
+	JoyConL: {
+		"-":            {1, 1},
+		"LStick":       {1, 3},
+		"Capture":      {1, 5},
+		"ChargingGrip": {1, 7},
+		"DOWN":         {2, 0},
+		"UP":           {2, 1},
+		"RIGHT":        {2, 2},
+		"LEFT":         {2, 3},
+		"SR":           {2, 4},
+		"SL":           {2, 5},
+		"L":            {2, 6},
+		"ZL":           {2, 7},
+	},
+	JoyConR: {
+		"Y":            {0, 0},
+		"X":            {0, 1},
+		"B":            {0, 2},
+		"A":            {0, 3},
+		"SR":           {0, 4},
+		"SL":           {0, 5},
+		"R":            {0, 6},
+		"ZR":           {0, 7},
+		"+":            {1, 0},
+		"RStick":       {1, 2},
+		"Home":         {1, 4},
+		"ChargingGrip": {1, 7},
+	},
+	NesL: nesButtonMap,
+	NesR: nesButtonMap,
+	Snes: {
+		"Y":      {0, 0},
+		"X":      {0, 1},
+		"B":      {0, 2},
+		"A":      {0, 3},
+		"R":      {0, 6},
+		"ZR":     {0, 7},
+		"START":  {1, 0},
+		"SELECT": {1, 1},
+		"DOWN":   {2, 0},
+		"UP":     {2, 1},
+		"RIGHT":  {2, 2},
+		"LEFT":   {2, 3},
+		"L":      {2, 6},
+		"ZL":     {2, 7},
+	},
+	// C buttons reuse face buttons, Z is reported as ZL and ZR as
+	// left stick press
+	N64: {
+		"CUP":     {0, 0},
+		"CLEFT":   {0, 1},
+		"B":       {0, 2},
+		"A":       {0, 3},
+		"R":       {0, 6},
+		"CDOWN":   {0, 7},
+		"START":   {1, 0},
+		"CRIGHT":  {1, 1},
+		"ZR":      {1, 3},
+		"Home":    {1, 4},
+		"Capture": {1, 5},
+		"DOWN":    {2, 0},
+		"UP":      {2, 1},
+		"RIGHT":   {2, 2},
+		"LEFT":    {2, 3},
+		"L":       {2, 6},
+		"Z":       {2, 7},
+	},
+	// C is reported as R, Z as L and MODE as ZR
+	Genesis: {
+		"Y":       {0, 0},
+		"X":       {0, 1},
+		"B":       {0, 2},
+		"A":       {0, 3},
+		"C":       {0, 6},
+		"MODE":    {0, 7},
+		"START":   {1, 0},
+		"Home":    {1, 4},
+		"Capture": {1, 5},
+		"DOWN":    {2, 0},
+		"UP":      {2, 1},
+		"RIGHT":   {2, 2},
+		"LEFT":    {2, 3},
+		"Z":       {2, 6},
+	},
+}
+
+var nesButtonMap = map[string]button{
+	"B":      {0, 2},
+	"A":      {0, 3},
+	"R":      {0, 6},
+	"START":  {1, 0},
+	"SELECT": {1, 1},
+	"DOWN":   {2, 0},
+	"UP":     {2, 1},
+	"RIGHT":  {2, 2},
+	"LEFT":   {2, 3},
+	"L":      {2, 6},
+}
@@ -1,10 +1,15 @@
 package joycontrol
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"dio.wtf/joycontrol/joycontrol/log"
 	"github.com/godbus/dbus/v5"
+	"github.com/google/uuid"
 	"github.com/muka/go-bluetooth/bluez"
 	"github.com/muka/go-bluetooth/bluez/profile/adapter"
 	"github.com/muka/go-bluetooth/bluez/profile/device"
@@ -18,33 +23,77 @@ type Device struct {
 	deviceId   string
 }
 
-func NewDevice() (d *Device, err error) {
+// AdapterInfo describes a Bluetooth adapter known to BlueZ.
+type AdapterInfo struct {
+	Name    string // hci name, e.g. hci0
+	Path    string
+	Address string
+	Alias   string
+	Powered bool
+}
+
+// ListAdapters returns the adapters sorted by hci name.
+func ListAdapters() (adapters []AdapterInfo, err error) {
 	objects, err := getManagedObjects()
 	if nil != err {
 		return
 	}
 
-	var adapter1 *adapter.Adapter1
-	var objectPath string
 	for path, ifaces := range objects {
-		if _, ok := ifaces[adapter.Adapter1Interface]; ok {
-			dev, err := adapter.NewAdapter1(path)
-			if nil != err {
-				return nil, err
-			}
-			adapter1 = dev
-			objectPath = string(path)
+		iface, ok := ifaces[adapter.Adapter1Interface]
+		if !ok {
+			continue
+		}
+		prop := new(adapter.Adapter1Properties)
+		if prop, err = prop.FromDBusMap(iface); nil != err {
+			return nil, err
+		}
+		s := strings.Split(string(path), "/")
+		adapters = append(adapters, AdapterInfo{
+			Name:    s[len(s)-1],
+			Path:    string(path),
+			Address: prop.Address,
+			Alias:   prop.Alias,
+			Powered: prop.Powered,
+		})
+	}
+	sort.Slice(adapters, func(i, j int) bool {
+		return adapters[i].Name < adapters[j].Name
+	})
+	return
+}
+
+// NewDevice uses the adapter with the given hci name or address, ""
+// picks the first one.
+func NewDevice(name string) (d *Device, err error) {
+	adapters, err := ListAdapters()
+	if nil != err {
+		return
+	}
+
+	var info *AdapterInfo
+	for i := range adapters {
+		if name == "" || name == adapters[i].Name || strings.EqualFold(name, adapters[i].Address) {
+			info = &adapters[i]
 			break
 		}
 	}
+	if info == nil {
+		if name == "" {
+			return nil, errors.New("no bluetooth adapter")
+		}
+		return nil, fmt.Errorf("no bluetooth adapter %s", name)
+	}
 
-	s := strings.Split(string(objectPath), "/")
-	deviceId := s[len(s)-1]
-	log.DebugF("Using adapter under object path: %s", objectPath)
+	adapter1, err := adapter.NewAdapter1(dbus.ObjectPath(info.Path))
+	if nil != err {
+		return
+	}
+	log.DebugF("Using adapter under object path: %s", info.Path)
 	return &Device{
 		Adapter1:   adapter1,
-		devicePath: objectPath,
-		deviceId:   deviceId,
+		devicePath: info.Path,
+		deviceId:   info.Name,
 	}, nil
 }
 
@@ -61,7 +110,7 @@ func (d *Device) PrepairedSwitches() (paths []dbus.ObjectPath, err error) {
 			if nil != err {
 				return
 			}
-			if prop.Name == "Nintendo Switch" {
+			if string(prop.Adapter) == d.devicePath && prop.Name == "Nintendo Switch" {
 				paths = append(paths, path)
 			}
 		}
@@ -79,6 +128,27 @@ func (d *Device) Reset() error {
 	return err
 }
 
+var (
+	profileRegistered bool
+	profileMux        sync.Mutex
+)
+
+// registerProfile registers the HID profile once, the SDP record is
+// shared by all adapters and controllers.
+func registerProfile(d *Device, options map[string]interface{}) error {
+	profileMux.Lock()
+	defer profileMux.Unlock()
+
+	if profileRegistered {
+		return nil
+	}
+	if err := d.RegisterProfile(HID_PATH, uuid.NewString(), options); nil != err {
+		return err
+	}
+	profileRegistered = true
+	return nil
+}
+
 func (d *Device) RegisterProfile(profilePath, uuid string, options map[string]interface{}) error {
 	mgr, err := profile.NewProfileManager1()
 	if nil != err {
@@ -101,7 +171,8 @@ func (d *Device) FindConnectedAdapter() (paths []string, err error) {
 			if nil != err {
 				return
 			}
-			if prop.Connected && (prop.Name == "Nintendo Switch" || prop.Alias == "Nintendo Switch") {
+			if string(prop.Adapter) == d.devicePath && prop.Connected &&
+				(prop.Name == "Nintendo Switch" || prop.Alias == "Nintendo Switch") {
 				paths = append(paths, string(path))
 			}
 		}
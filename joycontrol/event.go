@@ -0,0 +1,47 @@
+package joycontrol
+
+// Event is a change of the connection with the console.
+type Event uint8
+
+const (
+	EventConnected    Event = iota
+	EventDisconnected       // The console closed the connection or asked to
+	EventPairing            // Waiting for a console to pair again
+	EventPairingReset       // The console cleared the pairing info
+	EventLowPower           // The console is going to sleep
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventConnected:
+		return "Connected"
+	case EventDisconnected:
+		return "Disconnected"
+	case EventPairing:
+		return "Pairing"
+	case EventPairingReset:
+		return "PairingReset"
+	case EventLowPower:
+		return "LowPower"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// OnEvent registers a handler called on each connection event, from
+// the goroutine running the server.
+func (s *Server) OnEvent(handler func(Event)) {
+	s.eventMux.Lock()
+	defer s.eventMux.Unlock()
+	s.eventHandlers = append(s.eventHandlers, handler)
+}
+
+func (s *Server) emit(event Event) {
+	s.eventMux.RLock()
+	handlers := s.eventHandlers
+	s.eventMux.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
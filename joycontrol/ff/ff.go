@@ -0,0 +1,107 @@
+package ff
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// https://www.kernel.org/doc/html/latest/input/ff.html
+
+const ffRumble = 0x50
+
+// ffEffect mirrors struct ff_effect of linux/input.h with the union
+// sized as its largest member, struct ff_periodic_effect.
+type ffEffect struct {
+	effectType uint16
+	id         int16
+	direction  uint16
+	trigger    [2]uint16 // button, interval
+	replay     [2]uint16 // length, delay
+	u          struct {
+		strongMagnitude uint16
+		weakMagnitude   uint16
+		_               [14]byte
+		_               uint32
+		_               uintptr
+	}
+}
+
+// inputEvent mirrors struct input_event of linux/input.h.
+type inputEvent struct {
+	time      unix.Timeval
+	eventType uint16
+	code      uint16
+	value     int32
+}
+
+var (
+	eviocsff  = ioctlWrite('E', 0x80, unsafe.Sizeof(ffEffect{}))
+	eviocrmff = ioctlWrite('E', 0x81, unsafe.Sizeof(int32(0)))
+)
+
+func ioctlWrite(t, nr byte, size uintptr) uintptr {
+	return 1<<30 | size<<16 | uintptr(t)<<8 | uintptr(nr)
+}
+
+// Device is an evdev force-feedback device, like /dev/input/eventN
+// of a gamepad or a uinput virtual device.
+type Device struct {
+	fd int
+	id int16
+}
+
+func Open(path string) (*Device, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if nil != err {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return &Device{fd: fd, id: -1}, nil
+}
+
+// Rumble plays a FF_RUMBLE effect until changed, magnitudes of the
+// strong and weak motors range from 0 to 0xFFFF.
+func (d *Device) Rumble(strong, weak uint16) error {
+	if strong == 0 && weak == 0 {
+		return d.play(false)
+	}
+
+	effect := ffEffect{effectType: ffRumble, id: d.id}
+	effect.u.strongMagnitude = strong
+	effect.u.weakMagnitude = weak
+	// Zero length plays until stopped
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(d.fd), eviocsff, uintptr(unsafe.Pointer(&effect))); errno != 0 {
+		return fmt.Errorf("upload effect: %w", errno)
+	}
+	d.id = effect.id
+	return d.play(true)
+}
+
+func (d *Device) play(on bool) error {
+	if d.id < 0 {
+		return nil
+	}
+
+	event := inputEvent{
+		time:      unix.NsecToTimeval(time.Now().UnixNano()),
+		eventType: unix.EV_FF,
+		code:      uint16(d.id),
+	}
+	if on {
+		event.value = 1
+	}
+	buf := (*[unsafe.Sizeof(inputEvent{})]byte)(unsafe.Pointer(&event))
+	_, err := unix.Write(d.fd, buf[:])
+	return err
+}
+
+func (d *Device) Close() error {
+	if d.id >= 0 {
+		_ = d.play(false)
+		unix.Syscall(unix.SYS_IOCTL, uintptr(d.fd), eviocrmff, uintptr(d.id))
+		d.id = -1
+	}
+	return unix.Close(d.fd)
+}
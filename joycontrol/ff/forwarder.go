@@ -0,0 +1,82 @@
+package ff
+
+import (
+	"math"
+	"sync"
+
+	"dio.wtf/joycontrol/joycontrol/log"
+	R "dio.wtf/joycontrol/joycontrol/report"
+)
+
+// Forwarder plays the rumble sent by the console on a Device, the
+// low band drives the strong motor and the high band the weak one.
+type Forwarder struct {
+	device  *Device
+	updates chan R.RumbleData
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewForwarder(device *Device) *Forwarder {
+	f := &Forwarder{
+		device:  device,
+		updates: make(chan R.RumbleData, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Handle queues rumble without blocking, it can be registered with
+// Controller.OnRumble. Only the latest pending rumble is played,
+// rumble after Close is dropped.
+func (f *Forwarder) Handle(rumble R.RumbleData) {
+	for {
+		select {
+		case <-f.stop:
+			return
+		case f.updates <- rumble:
+			return
+		default:
+		}
+		select {
+		case <-f.updates:
+		default:
+		}
+	}
+}
+
+// Close stops the rumble and closes the device, the report loop may
+// still call Handle.
+func (f *Forwarder) Close() (err error) {
+	f.once.Do(func() {
+		close(f.stop)
+		<-f.done
+		err = f.device.Close()
+	})
+	return
+}
+
+func (f *Forwarder) run() {
+	defer close(f.done)
+
+	for {
+		var rumble R.RumbleData
+		select {
+		case <-f.stop:
+			return
+		case rumble = <-f.updates:
+		}
+		strong := magnitude(math.Max(rumble.Left.LowAmplitude, rumble.Right.LowAmplitude))
+		weak := magnitude(math.Max(rumble.Left.HighAmplitude, rumble.Right.HighAmplitude))
+		if err := f.device.Rumble(strong, weak); nil != err {
+			log.ErrorF("forward rumble: %v", err)
+		}
+	}
+}
+
+func magnitude(amplitude float64) uint16 {
+	return uint16(math.Round(math.Max(0, math.Min(1, amplitude)) * math.MaxUint16))
+}
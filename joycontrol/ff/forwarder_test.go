@@ -0,0 +1,204 @@
+package ff
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	R "dio.wtf/joycontrol/joycontrol/report"
+	"golang.org/x/sys/unix"
+)
+
+// https://www.kernel.org/doc/html/latest/input/uinput.html
+
+const (
+	evUinput   = 0x0101
+	uiFfUpload = 1
+	uiFfErase  = 2
+)
+
+// uinputSetup mirrors struct uinput_setup of linux/uinput.h.
+type uinputSetup struct {
+	id           [4]uint16 // bustype, vendor, product, version
+	name         [80]byte
+	ffEffectsMax uint32
+}
+
+// uinputFfUpload mirrors struct uinput_ff_upload.
+type uinputFfUpload struct {
+	requestId uint32
+	retval    int32
+	effect    ffEffect
+	old       ffEffect
+}
+
+// uinputFfErase mirrors struct uinput_ff_erase.
+type uinputFfErase struct {
+	requestId uint32
+	retval    int32
+	effectId  uint32
+}
+
+var (
+	uiDevCreate      = uintptr('U'<<8 | 1)
+	uiDevDestroy     = uintptr('U'<<8 | 2)
+	uiDevSetup       = ioctlWrite('U', 3, unsafe.Sizeof(uinputSetup{}))
+	uiSetEvbit       = ioctlWrite('U', 100, unsafe.Sizeof(int32(0)))
+	uiSetFfbit       = ioctlWrite('U', 107, unsafe.Sizeof(int32(0)))
+	uiBeginFfUpload  = 2<<30 | ioctlWrite('U', 200, unsafe.Sizeof(uinputFfUpload{}))
+	uiEndFfUpload    = ioctlWrite('U', 201, unsafe.Sizeof(uinputFfUpload{}))
+	uiBeginFfErase   = 2<<30 | ioctlWrite('U', 202, unsafe.Sizeof(uinputFfErase{}))
+	uiEndFfErase     = ioctlWrite('U', 203, unsafe.Sizeof(uinputFfErase{}))
+	uiGetSysnameSize = uintptr(64)
+	uiGetSysname     = 2<<30 | uiGetSysnameSize<<16 | 'U'<<8 | 44
+)
+
+func ioctl(fd int, req, arg uintptr) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func TestForwarderUinput(t *testing.T) {
+	ui, err := unix.Open("/dev/uinput", unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if nil != err {
+		t.Skipf("uinput unavailable: %v", err)
+	}
+	defer unix.Close(ui)
+
+	device, err := Open(createRumbleDevice(t, ui))
+	if nil != err {
+		t.Fatal(err)
+	}
+	forwarder := NewForwarder(device)
+
+	effects := make(chan ffEffect, 4)
+	plays := make(chan int32, 4)
+	stop := make(chan struct{})
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		serveUinput(t, ui, effects, plays, stop)
+	}()
+	defer func() {
+		close(stop)
+		<-served
+	}()
+
+	forwarder.Handle(R.RumbleData{Left: R.Rumble{LowAmplitude: 1}, Right: R.Rumble{HighAmplitude: 0.5}})
+	select {
+	case effect := <-effects:
+		if effect.effectType != ffRumble || effect.u.strongMagnitude != 0xFFFF || effect.u.weakMagnitude != 0x8000 {
+			t.Errorf("got effect %04X strong %04X weak %04X", effect.effectType, effect.u.strongMagnitude, effect.u.weakMagnitude)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no effect uploaded")
+	}
+	select {
+	case value := <-plays:
+		if value != 1 {
+			t.Errorf("got play value %d, expect 1", value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("effect not played")
+	}
+
+	if err := forwarder.Close(); nil != err {
+		t.Error(err)
+	}
+	// The report loop may still run after Close
+	forwarder.Handle(R.RumbleData{})
+}
+
+// createRumbleDevice creates a uinput device supporting FF_RUMBLE and
+// returns its evdev node.
+func createRumbleDevice(t *testing.T, ui int) string {
+	if err := ioctl(ui, uiSetEvbit, unix.EV_FF); nil != err {
+		t.Fatalf("UI_SET_EVBIT: %v", err)
+	}
+	if err := ioctl(ui, uiSetFfbit, ffRumble); nil != err {
+		t.Fatalf("UI_SET_FFBIT: %v", err)
+	}
+	setup := uinputSetup{id: [4]uint16{0x06, 0x057E, 0x2009, 1}, ffEffectsMax: 1}
+	copy(setup.name[:], "joycontrol rumble test")
+	if err := ioctl(ui, uiDevSetup, uintptr(unsafe.Pointer(&setup))); nil != err {
+		t.Fatalf("UI_DEV_SETUP: %v", err)
+	}
+	if err := ioctl(ui, uiDevCreate, 0); nil != err {
+		t.Fatalf("UI_DEV_CREATE: %v", err)
+	}
+	t.Cleanup(func() { ioctl(ui, uiDevDestroy, 0) })
+
+	sysname := make([]byte, uiGetSysnameSize)
+	if err := ioctl(ui, uiGetSysname, uintptr(unsafe.Pointer(&sysname[0]))); nil != err {
+		t.Fatalf("UI_GET_SYSNAME: %v", err)
+	}
+	name := strings.TrimRight(string(sysname), "\x00")
+
+	// The node is created by udev, which may lag or be missing
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		events, _ := filepath.Glob(filepath.Join("/sys/devices/virtual/input", name, "event*"))
+		if len(events) == 0 {
+			continue
+		}
+		path := filepath.Join("/dev/input", filepath.Base(events[0]))
+		if _, err := os.Stat(path); nil == err {
+			return path
+		}
+	}
+	t.Skipf("no evdev node for %s", name)
+	return ""
+}
+
+// serveUinput answers the effect uploads and erases of the evdev side
+// until stop, like a force-feedback driver would.
+func serveUinput(t *testing.T, ui int, effects chan<- ffEffect, plays chan<- int32, stop <-chan struct{}) {
+	var event inputEvent
+	buf := (*[unsafe.Sizeof(inputEvent{})]byte)(unsafe.Pointer(&event))
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if n, _ := unix.Poll([]unix.PollFd{{Fd: int32(ui), Events: unix.POLLIN}}, 50); n <= 0 {
+			continue
+		}
+		if _, err := unix.Read(ui, buf[:]); nil != err {
+			continue
+		}
+
+		switch {
+		case event.eventType == evUinput && event.code == uiFfUpload:
+			upload := uinputFfUpload{requestId: uint32(event.value)}
+			if err := ioctl(ui, uiBeginFfUpload, uintptr(unsafe.Pointer(&upload))); nil != err {
+				t.Errorf("UI_BEGIN_FF_UPLOAD: %v", err)
+				continue
+			}
+			effects <- upload.effect
+			upload.retval = 0
+			if err := ioctl(ui, uiEndFfUpload, uintptr(unsafe.Pointer(&upload))); nil != err {
+				t.Errorf("UI_END_FF_UPLOAD: %v", err)
+			}
+		case event.eventType == evUinput && event.code == uiFfErase:
+			erase := uinputFfErase{requestId: uint32(event.value)}
+			if err := ioctl(ui, uiBeginFfErase, uintptr(unsafe.Pointer(&erase))); nil != err {
+				t.Errorf("UI_BEGIN_FF_ERASE: %v", err)
+				continue
+			}
+			erase.retval = 0
+			if err := ioctl(ui, uiEndFfErase, uintptr(unsafe.Pointer(&erase))); nil != err {
+				t.Errorf("UI_END_FF_ERASE: %v", err)
+			}
+		case event.eventType == unix.EV_FF:
+			select {
+			case plays <- event.value:
+			default:
+			}
+		}
+	}
+}
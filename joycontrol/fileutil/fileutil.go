@@ -0,0 +1,14 @@
+package fileutil
+
+import "os"
+
+// WriteFile replaces the file at path with data atomically, through a
+// temporary file renamed over it, so a crash never leaves a truncated
+// file behind.
+func WriteFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); nil != err {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
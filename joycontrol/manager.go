@@ -0,0 +1,124 @@
+package joycontrol
+
+import (
+	"fmt"
+	"sync"
+
+	C "dio.wtf/joycontrol/joycontrol/controller"
+	"dio.wtf/joycontrol/joycontrol/log"
+)
+
+// ControllerStatus is the state of a controller run by a Manager.
+type ControllerStatus struct {
+	Adapter   string
+	Type      C.ControllerType
+	Connected bool
+	Console   string // Address of the connected console
+	Player    int
+	Err       error // Why the controller could not start
+}
+
+// Manager runs several emulated controllers at once, one per
+// Bluetooth adapter, each with its own state and identity.
+type Manager struct {
+	mux     sync.RWMutex
+	servers []*Server
+	status  []ControllerStatus
+}
+
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Add emulates controller on the adapter with the given hci name or
+// address, an adapter runs a single controller.
+func (m *Manager) Add(adapter string, controller *C.Controller) (*Server, error) {
+	server, err := NewServer(controller, adapter)
+	if nil != err {
+		return nil, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, s := range m.servers {
+		if s.device.devicePath == server.device.devicePath {
+			return nil, fmt.Errorf("adapter %s already runs a controller", server.Adapter())
+		}
+	}
+
+	i := len(m.servers)
+	m.servers = append(m.servers, server)
+	m.status = append(m.status, ControllerStatus{Adapter: server.Adapter(), Type: controller.Type})
+	server.OnEvent(func(event Event) {
+		m.handleEvent(i, event)
+	})
+	return server, nil
+}
+
+// Start connects all controllers concurrently, it returns without
+// waiting for the consoles.
+func (m *Manager) Start() {
+	toggleCleanBluez(true)
+
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	for i, server := range m.servers {
+		go func(i int, server *Server) {
+			if err := server.start(); nil != err {
+				log.ErrorF("start controller on %s: %v", server.Adapter(), err)
+				m.mux.Lock()
+				m.status[i].Err = err
+				m.mux.Unlock()
+			}
+		}(i, server)
+	}
+}
+
+func (m *Manager) Stop() {
+	log.Debug("Gracefully shutting down controllers")
+	toggleCleanBluez(false)
+}
+
+func (m *Manager) Len() int {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return len(m.servers)
+}
+
+func (m *Manager) Server(i int) *Server {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.servers[i]
+}
+
+// Controller returns the controller i to send input to, in the order
+// they were added.
+func (m *Manager) Controller(i int) *C.Controller {
+	return m.Server(i).Controller()
+}
+
+func (m *Manager) Status() []ControllerStatus {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	status := append([]ControllerStatus{}, m.status...)
+	for i := range status {
+		status[i].Player = m.servers[i].Controller().Player()
+	}
+	return status
+}
+
+func (m *Manager) handleEvent(i int, event Event) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	switch event {
+	case EventConnected:
+		m.status[i].Connected = true
+		m.status[i].Console = m.servers[i].Peer()
+		m.status[i].Err = nil
+	case EventDisconnected:
+		m.status[i].Connected = false
+		m.status[i].Console = ""
+	}
+}
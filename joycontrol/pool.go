@@ -27,12 +27,14 @@ var (
 
 func AllocStandardReport() *R.InputReport {
 	report := standardPool.Get().(*R.InputReport)
+	*report = (*report)[:standardSize] // Simple HID reports are resliced
 	copy((*report)[:], emptyInputReport[:])
 	return report
 }
 
 func AllocNfcReport() *R.InputReport {
 	report := nfcPool.Get().(*R.InputReport)
+	*report = (*report)[:nfcSize]
 	copy((*report)[:], emptyInputReport[:])
 	return report
 }
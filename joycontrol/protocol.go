@@ -1,6 +1,7 @@
 package joycontrol
 
 import (
+	"encoding/binary"
 	"net"
 	"time"
 
@@ -22,13 +23,57 @@ func NewProtocol(mac net.HardwareAddr) *Protocol {
 	}
 }
 
-func (p Protocol) generateStandardReport(ctrl *C.Controller) (input *R.InputReport) {
+// generateReport returns the input report of the mode requested by
+// the console with SetInputReportMode.
+func (p *Protocol) generateReport(ctrl *C.Controller) (input *R.InputReport) {
 	p.updateTimer()
+	return p.generateModeReport(ctrl)
+}
+
+// generateModeReport is generateReport without advancing the timer.
+func (p *Protocol) generateModeReport(ctrl *C.Controller) (input *R.InputReport) {
+	switch ctrl.Mode {
+	case R.NfcMode:
+		return p.generateNfcReport(ctrl)
+	case R.McuUpdateMode:
+		return p.generateMcuUpdateReport(ctrl)
+	case R.SimpleHidMode:
+		return p.generateSimpleHidReport(ctrl)
+	default:
+		return p.generateStandardReport(ctrl)
+	}
+}
 
+func (p *Protocol) generateStandardReport(ctrl *C.Controller) (input *R.InputReport) {
 	input = AllocStandardReport()
 	input.SetReportId(R.StandardFullModeId)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
-	input.SetImuData(ctrl.ImuEnabled)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	if ctrl.ImuEnabled {
+		input.SetImuData(ctrl.ImuData())
+	}
+	return
+}
+
+// generateMcuUpdateReport returns a 0x23 report, MCU data replaces
+// the IMU samples. It has the size of 0x31 reports to hold the whole
+// MCU payload.
+func (p *Protocol) generateMcuUpdateReport(ctrl *C.Controller) (input *R.InputReport) {
+	input = AllocNfcReport()
+	input.SetReportId(R.McuUpdateId)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.SetMcuUpdateData(ctrl.McuData())
+	input.UpdateChecksum(crc8Checksum((*input)[R.McuUpdateDataOffset:]))
+	return
+}
+
+// generateSimpleHidReport returns a 0x3F report, it has no timer nor
+// status and carries the input state itself.
+func (p *Protocol) generateSimpleHidReport(ctrl *C.Controller) (input *R.InputReport) {
+	input = AllocStandardReport()
+	*input = (*input)[:R.SimpleHidLength]
+	input.SetReportId(R.SimpleHidId)
+	state := ctrl.SimpleHidState()
+	input.SetSimpleHidState(state.Buttons, state.Hat, state.Sticks)
 	return
 }
 
@@ -43,26 +88,44 @@ func (p *Protocol) processSubcommandReport(ctrl *C.Controller, output R.OutputRe
 		input = p.answerSetMode(ctrl, output)
 	case R.TriggerButtonsElapsedTime:
 		input = p.anwserTriggerButtonsElapsedTime(ctrl)
+	case R.SetHciState:
+		input = p.answerSetHciState(ctrl)
+	case R.ResetPairingInfo:
+		input = p.answerResetPairingInfo(ctrl)
 	case R.SetShipmentLowPowerState:
 		input = p.answerSetShipmentState(ctrl)
 	case R.SpiFlashRead:
 		input = p.answerSpiRead(ctrl, output)
+	case R.SpiFlashWrite:
+		input = p.answerSpiWrite(ctrl, output)
+	case R.SpiSectorErase:
+		input = p.answerSpiErase(ctrl, output)
 	case R.SetNfcMcuConfig:
 		input = p.answerSetNfcMcuConfig(ctrl, output)
 	case R.SetNfcMcuState:
 		input = p.answerSetNfcMcuState(ctrl, output)
 	case R.SetPlayerLights:
-		input = p.answerSetPlayerLights(ctrl)
+		input = p.answerSetPlayerLights(ctrl, output)
+	case R.SetHomeLight:
+		input = p.answerSetHomeLight(ctrl, output)
 	case R.EnableImu:
 		input = p.answerEnableImu(ctrl, output)
+	case R.SetImuSensitivity:
+		input = p.answerSetImuSensitivity(ctrl, output)
+	case R.WriteImuRegisters:
+		input = p.answerWriteImuRegisters(ctrl, output)
+	case R.ReadImuRegisters:
+		input = p.answerReadImuRegisters(ctrl, output)
 	case R.EnableVibration:
 		input = p.answerEnableVibration(ctrl)
+	case R.GetRegulatedVoltage:
+		input = p.answerGetRegulatedVoltage(ctrl)
 	default:
 		// Currently set so that the controller ignores any unknown
 		// subcommands. This is better than sending a NACK response
 		// since we'd just get stuck in an infinite loop arguing
 		// with the Switch.
-		input = p.generateStandardReport(ctrl)
+		input = p.generateModeReport(ctrl)
 	}
 	return
 }
@@ -72,7 +135,7 @@ func (p *Protocol) answerSetMode(ctrl *C.Controller, output R.OutputReport) (inp
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckSetInputReportMode()
 	return
 }
@@ -80,7 +143,7 @@ func (p *Protocol) answerSetMode(ctrl *C.Controller, output R.OutputReport) (inp
 func (p *Protocol) anwserTriggerButtonsElapsedTime(ctrl *C.Controller) (input *R.InputReport) {
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckTriggerButtonsElapsedTime()
 	return
 }
@@ -90,26 +153,92 @@ func (p *Protocol) answerDeviceInfo(ctrl *C.Controller) (input *R.InputReport) {
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
-	input.AckDeviceInfo(p.mac)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckDeviceInfo(p.mac, byte(ctrl.Type))
+	return
+}
+
+// answerSetHciState only acknowledges, the server drops the
+// connection once the reply is sent.
+func (p *Protocol) answerSetHciState(ctrl *C.Controller) (input *R.InputReport) {
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckSetHciState()
+	return
+}
+
+func (p *Protocol) answerResetPairingInfo(ctrl *C.Controller) (input *R.InputReport) {
+	if err := ctrl.Flash().EraseSector(C.PairingInfoAddr); nil != err {
+		log.ErrorF("reset pairing info: %v", err)
+	}
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckResetPairingInfo()
 	return
 }
 
 func (p *Protocol) answerSetShipmentState(ctrl *C.Controller) (input *R.InputReport) {
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckSetShipmentLowPowerState()
 	return
 }
 
 func (p *Protocol) answerSpiRead(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
 	args := output.SubcommandArgs()
+	addr := binary.LittleEndian.Uint32(args[:4])
+	size := int(args[4])
+	if size > R.SpiReadMaxSize {
+		size = R.SpiReadMaxSize
+	}
+	data := ctrl.Flash().Read(addr, size)
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
-	input.AckSpiFlashRead(args)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckSpiFlashRead(args, data)
+	return
+}
+
+func (p *Protocol) answerSpiWrite(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	args := output.SubcommandArgs()
+	addr := binary.LittleEndian.Uint32(args[:4])
+	size := int(args[4])
+	if size > len(args)-5 {
+		size = len(args) - 5
+	}
+
+	status := byte(0x00)
+	if err := ctrl.Flash().Write(addr, args[5:5+size]); nil != err {
+		log.ErrorF("spi write %#x: %v", addr, err)
+		status = 0x01
+	}
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckSpiFlashWrite(status)
+	return
+}
+
+func (p *Protocol) answerSpiErase(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	args := output.SubcommandArgs()
+	addr := binary.LittleEndian.Uint32(args[:4])
+
+	status := byte(0x00)
+	if err := ctrl.Flash().EraseSector(addr); nil != err {
+		log.ErrorF("spi erase %#x: %v", addr, err)
+		status = 0x01
+	}
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckSpiSectorErase(status)
 	return
 }
 
@@ -126,19 +255,25 @@ func (p *Protocol) answerSetNfcMcuConfig(ctrl *C.Controller, output R.OutputRepo
 				ctrl.SetMcuState(C.McuStandby)
 			case 0x04:
 				ctrl.SetMcuState(C.McuNfc)
+			case 0x05:
+				ctrl.SetMcuState(C.McuIr)
 			default:
 				log.DebugF("Unknown NFC MCU mode: %02x", args[1])
 			}
 		} else {
 			log.DebugF("Unknown NFC MCU subcommand: %02x", subcmd)
 		}
+	case R.ConfigureIr:
+		if reply := ctrl.ConfigureIr(output.McuCommandArgs()); reply != nil {
+			state = reply
+		}
 	default:
 		log.DebugF("Unknown NFC MCU command: %02x", output.McuCommand())
 	}
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckSetNfcMcuConfig(state)
 	input.UpdateChecksum(crc8Checksum((*input)[16:]))
 	return
@@ -150,44 +285,113 @@ func (p *Protocol) answerSetNfcMcuState(ctrl *C.Controller, output R.OutputRepor
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckSetNfcMcuState()
 	return
 }
 
-func (p *Protocol) answerSetPlayerLights(ctrl *C.Controller) (input *R.InputReport) {
-	ctrl.PlayerNumber = true
+func (p *Protocol) answerSetPlayerLights(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	args := output.SubcommandArgs()
+	ctrl.SetPlayerLights(C.PlayerLights(args[0]))
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckSetPlayerLights()
 	return
 }
 
+func (p *Protocol) answerSetHomeLight(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	ctrl.SetHomeLight(C.ParseHomeLight(output.SubcommandArgs()))
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckSetHomeLight()
+	return
+}
+
 func (p *Protocol) answerEnableImu(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
 	args := output.SubcommandArgs()
 	ctrl.ImuEnabled = args[0] == 0x01
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckEnableImu()
 	return
 }
 
+func (p *Protocol) answerSetImuSensitivity(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	ctrl.SetImuSensitivity(output.SubcommandArgs())
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckSetImuSensitivity()
+	return
+}
+
+func (p *Protocol) answerWriteImuRegisters(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	// Register address, always 0x01, value
+	args := output.SubcommandArgs()
+	ctrl.WriteImuRegister(args[0], args[2])
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckWriteImuRegisters()
+	return
+}
+
+func (p *Protocol) answerReadImuRegisters(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	// Start address, count up to 0x20
+	args := output.SubcommandArgs()
+	addr, count := args[0], args[1]
+	if count > 0x20 {
+		count = 0x20
+	}
+
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckReadImuRegisters(addr, ctrl.ReadImuRegisters(addr, count))
+	return
+}
+
 func (p *Protocol) answerEnableVibration(ctrl *C.Controller) (input *R.InputReport) {
 	ctrl.VibrationEnabled = true
 
 	input = AllocStandardReport()
 	input.SetReportId(R.SubcommandReplies)
-	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
 	input.AckEnableVibration()
 	return
 }
 
-func (p *Protocol) processNfcDataReport(ctrl *C.Controller, output R.OutputReport) {
-	// TODO: Handle Request NFC Data Report
+func (p *Protocol) answerGetRegulatedVoltage(ctrl *C.Controller) (input *R.InputReport) {
+	input = AllocStandardReport()
+	input.SetReportId(R.SubcommandReplies)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	input.AckGetRegulatedVoltage(ctrl.Voltage())
+	return
+}
+
+func (p *Protocol) processNfcDataReport(ctrl *C.Controller, output R.OutputReport) (input *R.InputReport) {
+	ctrl.HandleMcuRequest(output.McuCommand(), output.McuCommandArgs())
+	return p.generateReport(ctrl)
+}
+
+func (p *Protocol) generateNfcReport(ctrl *C.Controller) (input *R.InputReport) {
+	input = AllocNfcReport()
+	input.SetReportId(R.NfcMcuModeId)
+	input.FillStandardData(p.elapsed, ctrl.DeviceInfoRequired, ctrl.Status())
+	if ctrl.ImuEnabled {
+		input.SetImuData(ctrl.ImuData())
+	}
+	input.SetMcuData(ctrl.McuData())
+	input.UpdateChecksum(crc8Checksum((*input)[R.McuDataOffset:]))
+	return
 }
 
 func (p *Protocol) updateTimer() {
@@ -0,0 +1,72 @@
+package joycontrol
+
+import (
+	"testing"
+
+	C "dio.wtf/joycontrol/joycontrol/controller"
+	R "dio.wtf/joycontrol/joycontrol/report"
+)
+
+func TestGenerateReport(t *testing.T) {
+	p := NewProtocol(nil)
+	ctrl := C.NewController(C.ProController)
+
+	tests := []struct {
+		mode   R.InputReportMode
+		id     R.InputReportId
+		length int
+	}{
+		{R.StandFullMode, R.StandardFullModeId, standardSize},
+		{R.NfcMode, R.NfcMcuModeId, nfcSize},
+		{R.McuUpdateMode, R.McuUpdateId, nfcSize},
+		{R.SimpleHidMode, R.SimpleHidId, R.SimpleHidLength},
+	}
+	for _, test := range tests {
+		ctrl.Mode = test.mode
+		input := p.generateReport(ctrl)
+		if input.Id() != test.id || len(*input) != test.length {
+			t.Errorf("mode %02X: got report %02X of %d bytes, expect %02X of %d bytes",
+				test.mode, input.Id(), len(*input), test.id, test.length)
+		}
+		FreeReport(input)
+	}
+
+	// A resliced report is reused at full length
+	ctrl.Mode = R.StandFullMode
+	if input := p.generateReport(ctrl); len(*input) != standardSize {
+		t.Errorf("got %d bytes after a simple HID report", len(*input))
+	}
+}
+
+func TestNfcReportChecksum(t *testing.T) {
+	p := NewProtocol(nil)
+	ctrl := C.NewController(C.ProController)
+	ctrl.Mode = R.NfcMode
+
+	input := *p.generateReport(ctrl)
+	if input[R.McuDataOffset] != 0xFF {
+		t.Errorf("got MCU data % X, expect none", input[R.McuDataOffset:R.McuDataOffset+8])
+	}
+	if crc := crc8Checksum(input[R.McuDataOffset:]); input[len(input)-1] != crc {
+		t.Errorf("got checksum %02X, expect %02X", input[len(input)-1], crc)
+	}
+}
+
+func TestMcuUpdateReportData(t *testing.T) {
+	p := NewProtocol(nil)
+	ctrl := C.NewController(C.ProController)
+	ctrl.Mode = R.McuUpdateMode
+	ctrl.HandleMcuRequest(R.RequestMcuStatus, nil)
+
+	input := *p.generateReport(ctrl)
+	// The whole MCU payload fits before the checksum
+	if room := len(input) - 1 - R.McuUpdateDataOffset; room < C.McuDataLength-1 {
+		t.Errorf("got room for %d MCU bytes, expect %d", room, C.McuDataLength-1)
+	}
+	if input[R.McuUpdateDataOffset] != 0x01 {
+		t.Errorf("got MCU data % X, expect the status", input[R.McuUpdateDataOffset:R.McuUpdateDataOffset+8])
+	}
+	if crc := crc8Checksum(input[R.McuUpdateDataOffset:]); input[len(input)-1] != crc {
+		t.Errorf("got checksum %02X, expect %02X", input[len(input)-1], crc)
+	}
+}
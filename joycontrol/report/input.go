@@ -1,6 +1,7 @@
 package report
 
 import (
+	"encoding/binary"
 	"fmt"
 	"strings"
 )
@@ -11,6 +12,13 @@ const (
 	InputReportHeader byte = 0xA1
 	// InputReportLength int  = 363 // header + 362 Standard input report
 	InputReportLength int = 50
+
+	SpiReadMaxSize int = 0x1D // Max bytes fit in a subcommand reply
+
+	McuDataOffset       int = 50 // MCU payload of 0x31 reports, after the standard data
+	McuUpdateDataOffset int = 14 // MCU payload of 0x23 reports, in place of IMU data
+
+	SimpleHidLength int = 13 // header + 12 Simple HID input report
 )
 
 // InputReport represents report sent from the Controller to the Switch.
@@ -20,36 +28,45 @@ func (i InputReport) SetReportId(id InputReportId) {
 	i[1] = byte(id)
 }
 
-func (i InputReport) SetImuData(enabled bool) {
-	if !enabled {
-		return
-	}
+func (i InputReport) Id() InputReportId {
+	return InputReportId(i[1])
+}
 
-	data := []byte{
-		0x75, 0xFD, 0xFD, 0xFF, 0x09, 0x10, 0x21, 0x00, 0xD5,
-		0xFF, 0xE0, 0xFF, 0x72, 0xFD, 0xF9, 0xFF, 0x0A, 0x10,
-		0x22, 0x00, 0xD5, 0xFF, 0xE0, 0xFF, 0x76, 0xFD, 0xFC,
-		0xFF, 0x09, 0x10, 0x23, 0x00, 0xD5, 0xFF, 0xE0, 0xFF}
-	copy(i[14:14+len(data)], data)
+// SetImuData writes 3 IMU samples of accelerometer and gyroscope
+// XYZ values.
+func (i InputReport) SetImuData(data []byte) {
+	copy(i[14:14+36], data)
 }
 
-func (i InputReport) FillStandardData(elapsed int64, queryDeviceIno bool) {
+// SetMcuData writes the MCU payload of NFC/IR reports, the checksum
+// is updated separately.
+func (i InputReport) SetMcuData(data []byte) {
+	copy(i[McuDataOffset:len(i)-1], data)
+}
+
+// SetMcuUpdateData writes the MCU payload of MCU update reports, the
+// checksum is updated separately.
+func (i InputReport) SetMcuUpdateData(data []byte) {
+	copy(i[McuUpdateDataOffset:len(i)-1], data)
+}
+
+func (i InputReport) FillStandardData(elapsed int64, queryDeviceIno bool, status byte) {
 	i[2] = byte(elapsed)
 
 	if queryDeviceIno {
-		i[3] = 0x90 + 0x00 // Battery level + Connection info(Pro Controller)
+		i[3] = status // Battery level + Connection info
 
 		i[4] = 0x00 // Button state
 		i[5] = 0x00
 		i[6] = 0x00
 
-		i[7] = 0x00 // Left Stick state
-		i[8] = 0x00
-		i[9] = 0x00
+		i[7] = 0x00 // Left Stick state, centered
+		i[8] = 0x08
+		i[9] = 0x80
 
-		i[10] = 0x00 // Right Stick state
-		i[11] = 0x00
-		i[12] = 0x00
+		i[10] = 0x00 // Right Stick state, centered
+		i[11] = 0x08
+		i[12] = 0x80
 
 		i[13] = 0x80 // Vibrator
 	}
@@ -59,26 +76,31 @@ func (i InputReport) SetButtonState(data []byte) {
 	copy(i[4:7], data)
 }
 
+func (i InputReport) SetStickState(left, right []byte) {
+	copy(i[7:10], left)
+	copy(i[10:13], right)
+}
+
 func (i InputReport) AckSetInputReportMode() {
 	i[14] = 0x80                     // ACK without data
 	i[15] = byte(SetInputReportMode) // Subcommand Reply
 }
 
-func (i InputReport) AckDeviceInfo(mac []byte) {
+func (i InputReport) AckDeviceInfo(mac []byte, deviceType byte) {
 	i[14] = 0x82                    // ACK with data
 	i[15] = byte(RequestDeviceInfo) // Subcommand Reply
 
 	i[16] = 0x03 // Firmware version
 	i[17] = 0x8B
 
-	i[18] = 0x03 // Pro Controller
+	i[18] = deviceType // 0x01 Joy-Con (L), 0x02 Joy-Con (R), 0x03 Pro Controller
 
 	i[19] = 0x02 // Unknown Byte, always 2
 
 	copy(i[20:26], mac)
 
 	i[26] = 0x01 // Unknown byte, always 1
-	i[27] = 0x01 // Controller colours location
+	i[27] = 0x01 // Controller colours location, 0x01 read from SPI flash
 }
 
 func (i InputReport) AckTriggerButtonsElapsedTime() {
@@ -86,48 +108,41 @@ func (i InputReport) AckTriggerButtonsElapsedTime() {
 	i[15] = byte(TriggerButtonsElapsedTime) // Subcommand Reply
 }
 
+func (i InputReport) AckSetHciState() {
+	i[14] = 0x80              // ACK
+	i[15] = byte(SetHciState) // Subcommand Reply
+}
+
+func (i InputReport) AckResetPairingInfo() {
+	i[14] = 0x80                   // ACK
+	i[15] = byte(ResetPairingInfo) // Subcommand Reply
+}
+
 func (i InputReport) AckSetShipmentLowPowerState() {
 	i[14] = 0x80                           // ACK
 	i[15] = byte(SetShipmentLowPowerState) // Subcommand Reply
 }
 
 // https://github.com/dekuNukem/Nintendo_Switch_Reverse_Engineering/blob/master/spi_flash_notes.md
-func (i InputReport) AckSpiFlashRead(args []byte) {
-	lowEnd := args[0]
-	highEnd := args[1]
-	sectionRange := args[4]
-
+func (i InputReport) AckSpiFlashRead(args []byte, data []byte) {
 	i[14] = 0x90               // ACK
 	i[15] = byte(SpiFlashRead) // Subcommand Reply
 
-	i[16] = lowEnd       // Low byte in Little-Endian address
-	i[17] = highEnd      // High byte in Little-Endian address
-	i[20] = sectionRange // Section range
-
-	if lowEnd == 0x00 && highEnd == 0x60 {
-		// Serial number
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	} else if lowEnd == 0x50 && highEnd == 0x60 {
-		// Body #RGB color
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	} else if lowEnd == 0x80 && highEnd == 0x60 {
-		// Factory Sensor and Stick device parameters
-		// TODO: Copy NXBT
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	} else if lowEnd == 0x98 && highEnd == 0x60 {
-		// Factory Stick device parameters 2
-		// TODO: Copy NXBT
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	} else if lowEnd == 0x10 && highEnd == 0x80 {
-		// User Analog sticks calibration
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	} else if lowEnd == 0x3D && highEnd == 0x60 {
-		// Factory configuration & calibration 2
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	} else if lowEnd == 0x20 && highEnd == 0x60 {
-		// Factory configuration & calibration 1
-		replaceSlice(i[:], 21, 21+int(sectionRange), 0xFF)
-	}
+	copy(i[16:20], args[:4]) // Address in Little-Endian
+	i[20] = byte(len(data))  // Section range
+	copy(i[21:], data)
+}
+
+func (i InputReport) AckSpiFlashWrite(status byte) {
+	i[14] = 0x80                // ACK
+	i[15] = byte(SpiFlashWrite) // Subcommand Reply
+	i[16] = status              // 0x00 success, 0x01 write protected
+}
+
+func (i InputReport) AckSpiSectorErase(status byte) {
+	i[14] = 0x80                 // ACK
+	i[15] = byte(SpiSectorErase) // Subcommand Reply
+	i[16] = status               // 0x00 success, 0x01 write protected
 }
 
 func (i InputReport) AckSetNfcMcuConfig(data []byte) {
@@ -147,16 +162,58 @@ func (i InputReport) AckSetPlayerLights() {
 	i[15] = byte(SetPlayerLights) // Subcommand Reply
 }
 
+func (i InputReport) AckSetHomeLight() {
+	i[14] = 0x80               // ACK
+	i[15] = byte(SetHomeLight) // Subcommand Reply
+}
+
 func (i InputReport) AckEnableImu() {
 	i[14] = 0x80            // ACK
 	i[15] = byte(EnableImu) // Subcommand Reply
 }
 
+func (i InputReport) AckSetImuSensitivity() {
+	i[14] = 0x80                    // ACK
+	i[15] = byte(SetImuSensitivity) // Subcommand Reply
+}
+
+func (i InputReport) AckWriteImuRegisters() {
+	i[14] = 0x80                    // ACK
+	i[15] = byte(WriteImuRegisters) // Subcommand Reply
+}
+
+func (i InputReport) AckReadImuRegisters(addr byte, data []byte) {
+	i[14] = 0xC0                   // ACK with data
+	i[15] = byte(ReadImuRegisters) // Subcommand Reply
+
+	i[16] = addr            // Start address
+	i[17] = byte(len(data)) // Count
+	copy(i[18:], data)
+}
+
 func (i InputReport) AckEnableVibration() {
 	i[14] = 0x82                  // ACK
 	i[15] = byte(EnableVibration) // Subcommand Reply
 }
 
+func (i InputReport) AckGetRegulatedVoltage(voltage uint16) {
+	i[14] = 0xD0                      // ACK with data
+	i[15] = byte(GetRegulatedVoltage) // Subcommand Reply
+
+	binary.LittleEndian.PutUint16(i[16:18], voltage) // Voltage in units of 2.5mV
+}
+
+// SetSimpleHidState writes a 0x3F report as described by the SDP
+// HID descriptor: 16 buttons, a hat switch with 8 as neutral, then X,
+// Y, RX and RY axes as little-endian 16-bit values.
+func (i InputReport) SetSimpleHidState(buttons uint16, hat byte, sticks [4]uint16) {
+	binary.LittleEndian.PutUint16(i[2:4], buttons)
+	i[4] = hat & 0x0F
+	for n, v := range sticks {
+		binary.LittleEndian.PutUint16(i[5+n*2:], v)
+	}
+}
+
 func (i InputReport) UpdateChecksum(checksum byte) {
 	i[len(i)-1] = checksum
 }
@@ -168,6 +225,13 @@ func (i InputReport) String() string {
 	if id == SubcommandReplies {
 		builder.WriteString(fmt.Sprintf("--- %s Msg ---", Subcommand(i[15]).String()))
 	}
+	if id == SimpleHidId {
+		builder.WriteString("\nPayload:    ")
+		for _, p := range i {
+			builder.WriteString(fmt.Sprintf("0x%02X ", p))
+		}
+		return builder.String()
+	}
 	builder.WriteString("\nPayload:    ")
 	for _, p := range i[:14] {
 		builder.WriteString(fmt.Sprintf("0x%02X ", p))
@@ -43,6 +43,15 @@ func (o OutputReport) Id() OutputReportId {
 	return OutputReportId(o[1])
 }
 
+// Rumble decodes bytes 2-9, the left and right HD Rumble data sent
+// with RumbleOnly and RumbleAndSubcommand reports.
+func (o OutputReport) Rumble() RumbleData {
+	return RumbleData{
+		Left:  DecodeRumble(o[3:7]),
+		Right: DecodeRumble(o[7:11]),
+	}
+}
+
 func (o OutputReport) Subcommand() Subcommand {
 	b := o[11]
 	return Subcommand(b)
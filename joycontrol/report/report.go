@@ -34,7 +34,7 @@ const (
 	SubcommandReplies  InputReportId = 0x21
 	StandardFullModeId InputReportId = 0x30
 	NfcMcuModeId       InputReportId = 0x31
-	// UpdateNfcReport    InputReportId = 0x23
+	McuUpdateId        InputReportId = 0x23
 	// UnknownInputType   InputReportId = 0x32 | 0x33
 )
 
@@ -43,6 +43,7 @@ type InputReportMode uint8
 const (
 	StandFullMode InputReportMode = 0x30
 	NfcMode       InputReportMode = 0x31
+	McuUpdateMode InputReportMode = 0x23
 	SimpleHidMode InputReportMode = 0x3F
 )
 
@@ -53,13 +54,22 @@ const (
 	RequestDeviceInfo         Subcommand = 0x02
 	SetInputReportMode        Subcommand = 0x03
 	TriggerButtonsElapsedTime Subcommand = 0x04
+	SetHciState               Subcommand = 0x06
+	ResetPairingInfo          Subcommand = 0x07
 	SetShipmentLowPowerState  Subcommand = 0x08
 	SpiFlashRead              Subcommand = 0x10
+	SpiFlashWrite             Subcommand = 0x11
+	SpiSectorErase            Subcommand = 0x12
 	SetNfcMcuConfig           Subcommand = 0x21
 	SetNfcMcuState            Subcommand = 0x22
 	SetPlayerLights           Subcommand = 0x30
+	SetHomeLight              Subcommand = 0x38
 	EnableImu                 Subcommand = 0x40
+	SetImuSensitivity         Subcommand = 0x41
+	WriteImuRegisters         Subcommand = 0x42
+	ReadImuRegisters          Subcommand = 0x43
 	EnableVibration           Subcommand = 0x48
+	GetRegulatedVoltage       Subcommand = 0x50
 )
 
 func (s Subcommand) String() string {
@@ -70,20 +80,64 @@ func (s Subcommand) String() string {
 		return "SetInputReportMode"
 	case 0x04:
 		return "TriggerButtonsElapsedTime"
+	case 0x06:
+		return "SetHciState"
+	case 0x07:
+		return "ResetPairingInfo"
 	case 0x08:
 		return "SetShipmentLowPowerState"
 	case 0x10:
 		return "SpiFlashRead"
+	case 0x11:
+		return "SpiFlashWrite"
+	case 0x12:
+		return "SpiSectorErase"
 	case 0x21:
 		return "SetNfcMcuConfig"
 	case 0x22:
 		return "SetNfcMcuState"
 	case 0x30:
 		return "SetPlayerLights"
+	case 0x38:
+		return "SetHomeLight"
 	case 0x40:
 		return "EnableImu"
+	case 0x41:
+		return "SetImuSensitivity"
+	case 0x42:
+		return "WriteImuRegisters"
+	case 0x43:
+		return "ReadImuRegisters"
 	case 0x48:
 		return "EnableVibration"
+	case 0x50:
+		return "GetRegulatedVoltage"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HciState is the argument of SetHciState, every state but
+// HciDisconnect makes the controller connect again.
+type HciState uint8
+
+const (
+	HciDisconnect    HciState = 0x00 // Sleep in page scan mode
+	HciReconnect     HciState = 0x01 // Reboot and reconnect in page mode
+	HciPair          HciState = 0x02 // Reboot and enter pairing mode
+	HciReconnectHome HciState = 0x04 // Reboot and reconnect in HOME mode
+)
+
+func (h HciState) String() string {
+	switch h {
+	case 0x00:
+		return "Disconnect"
+	case 0x01:
+		return "Reconnect"
+	case 0x02:
+		return "Pair"
+	case 0x04:
+		return "ReconnectHome"
 	default:
 		return "UNKNOWN"
 	}
@@ -96,6 +150,7 @@ const (
 	RequestMcuStatus     McuCommand = 0x01
 	RequestNfcDataReport McuCommand = 0x02
 	RequestIrDataReport  McuCommand = 0x03
+	ConfigureIr          McuCommand = 0x23
 )
 
 func (m McuCommand) String() string {
@@ -108,13 +163,9 @@ func (m McuCommand) String() string {
 		return "RequestNfcDataReport"
 	case 0x03:
 		return "RequestIrDataReport"
+	case 0x23:
+		return "ConfigureIr"
 	default:
 		return "UNKNOWN"
 	}
 }
-
-func replaceSlice(slice []byte, start, end int, replacement byte) {
-	for i := start; i < end; i++ {
-		slice[i] = replacement
-	}
-}
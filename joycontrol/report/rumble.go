@@ -0,0 +1,83 @@
+package report
+
+import (
+	"fmt"
+	"math"
+)
+
+// https://github.com/dekuNukem/Nintendo_Switch_Reverse_Engineering/blob/master/rumble_data_table.md
+
+// Rumble is the decoded HD Rumble data of a single actuator, two
+// bands with frequency in Hz and amplitude in range [0, 1].
+type Rumble struct {
+	HighFrequency float64
+	HighAmplitude float64
+	LowFrequency  float64
+	LowAmplitude  float64
+}
+
+// DefaultRumble is the neutral data sent when nothing vibrates.
+var DefaultRumble = DecodeRumble([]byte{0x00, 0x01, 0x40, 0x40})
+
+// DecodeRumble reads the 4 bytes encoding of one actuator.
+func DecodeRumble(data []byte) Rumble {
+	// High band: 9-bit frequency over byte 0 and bit 0 of byte 1,
+	// amplitude in the upper 7 bits of byte 1
+	hf := uint16(data[1]&0x01)<<8 | uint16(data[0])
+	hfAmp := data[1] >> 1
+
+	// Low band: 7-bit frequency in byte 2, amplitude over byte 3 and
+	// bit 7 of byte 2
+	lf := data[2] & 0x7F
+	lfAmp := byte(0)
+	if data[3] >= 0x40 {
+		lfAmp = (data[3]-0x40)<<1 | data[2]>>7
+	}
+
+	return Rumble{
+		HighFrequency: decodeFrequency(byte(hf>>2) + 0x60),
+		HighAmplitude: decodeAmplitude(hfAmp),
+		LowFrequency:  decodeFrequency(lf + 0x40),
+		LowAmplitude:  decodeAmplitude(lfAmp),
+	}
+}
+
+func (r Rumble) IsZero() bool {
+	return r.HighAmplitude == 0 && r.LowAmplitude == 0
+}
+
+func (r Rumble) String() string {
+	return fmt.Sprintf("HF %.0fHz %.2f LF %.0fHz %.2f", r.HighFrequency, r.HighAmplitude, r.LowFrequency, r.LowAmplitude)
+}
+
+// RumbleData holds both actuators of an output report, a Joy-Con
+// only uses its own side.
+type RumbleData struct {
+	Left  Rumble
+	Right Rumble
+}
+
+func (r RumbleData) String() string {
+	return fmt.Sprintf("Left: %s, Right: %s", r.Left, r.Right)
+}
+
+func decodeFrequency(encoded byte) float64 {
+	return 10 * math.Exp2(float64(encoded)/32)
+}
+
+// decodeAmplitude inverts the piecewise encoding of the rumble data
+// table, values are within 1% of the table.
+func decodeAmplitude(encoded byte) float64 {
+	var amp float64
+	switch {
+	case encoded == 0:
+		return 0
+	case encoded < 0x10:
+		amp = 0.12 * float64(encoded) / 0x10
+	case encoded < 0x20:
+		amp = math.Exp2(float64(encoded)/16) / 17
+	default:
+		amp = math.Exp2(float64(encoded)/32) / 8.7
+	}
+	return math.Min(1, amp)
+}
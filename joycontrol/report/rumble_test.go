@@ -0,0 +1,28 @@
+package report
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodeRumble(t *testing.T) {
+	if r := DefaultRumble; r.HighFrequency != 320 || r.LowFrequency != 160 || !r.IsZero() {
+		t.Errorf("unexpected default rumble: %s", r)
+	}
+
+	// 0x72 0x8B 0x1E 0x72: hf 0x172 amp 0x45, lf 0x1E amp 0x64
+	r := DecodeRumble([]byte{0x72, 0x8B, 0x1E, 0x72})
+	t.Log(r)
+	if math.Abs(r.HighFrequency-10*math.Exp2(float64(0x5C+0x60)/32)) > 0.01 {
+		t.Errorf("unexpected high frequency: %f", r.HighFrequency)
+	}
+	if math.Abs(r.LowFrequency-10*math.Exp2(float64(0x1E+0x40)/32)) > 0.01 {
+		t.Errorf("unexpected low frequency: %f", r.LowFrequency)
+	}
+	if r.LowAmplitude != 1 {
+		t.Errorf("unexpected low amplitude: %f", r.LowAmplitude)
+	}
+	if r.HighAmplitude < 0.5 || r.HighAmplitude > 0.6 {
+		t.Errorf("unexpected high amplitude: %f", r.HighAmplitude)
+	}
+}
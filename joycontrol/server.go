@@ -3,7 +3,9 @@ package joycontrol
 import (
 	_ "embed"
 	"errors"
+	"io"
 	"net"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -12,56 +14,282 @@ import (
 	"dio.wtf/joycontrol/joycontrol/log"
 	R "dio.wtf/joycontrol/joycontrol/report"
 	"github.com/godbus/dbus/v5"
-	"github.com/google/uuid"
 	"golang.org/x/exp/slices"
 	"golang.org/x/sys/unix"
 )
 
+// The Switch tells controllers apart by device info and name, the
+// same HID record is advertised for every controller type.
+//
 //go:embed sdp/controller.xml
 var sdpRecord string
 
 const (
 	GAMEPAD_CLASS = "0x002508"
 	HID_PATH      = "/joysticker/controller"
-	ALIAS         = "Pro Controller"
 )
 
+// ErrDropped is returned by Run when Disconnect drops the connection.
+var ErrDropped = errors.New("connection dropped by the controller")
+
 type Server struct {
 	device     *Device
 	protocol   *Protocol
 	controller *C.Controller
 	mac        net.HardwareAddr
+	console    string // Paired console to reconnect to
+	peer       string // Connected console
+	consoles   *ConsoleStore
+	dial       func(console string) (itr, ctrl int, err error) // See dialL2cap
+
+	stalledSince  time.Time // First failed write since the last sent report
+	dropping      bool
+	forgetOnReset bool
 
 	needWatch    bool
 	stateUpdated bool
 	mux          sync.RWMutex
 
+	eventHandlers []func(Event)
+	eventMux      sync.RWMutex
+
 	output R.OutputReport
 }
 
-func NewServer(controller *C.Controller) *Server {
-	device, _ := NewDevice()
-	addr, _ := device.GetAddress()
-	mac, _ := net.ParseMAC(addr)
+// NewServer emulates controller on the adapter with the given hci
+// name or address, "" picks the first one. L2CAP sockets are bound to
+// that adapter.
+func NewServer(controller *C.Controller, adapter string) (*Server, error) {
+	device, err := NewDevice(adapter)
+	if nil != err {
+		return nil, err
+	}
+	addr, err := device.GetAddress()
+	if nil != err {
+		return nil, err
+	}
+	mac, err := net.ParseMAC(addr)
+	if nil != err {
+		return nil, err
+	}
 	protocol := NewProtocol(mac)
-	return &Server{
+	s := &Server{
 		device:     device,
 		protocol:   protocol,
 		controller: controller,
 		mac:        mac,
 		output:     make([]byte, R.OutputReportLength),
 	}
+	s.dial = s.dialL2cap
+	return s, nil
 }
 
+// Start waits for a console on the pairing screen and serves it in
+// the background, see serve for when the session ends.
 func (s *Server) Start() {
 	toggleCleanBluez(true)
 
-	if err := s.Setup(); nil != err {
+	if err := s.start(); nil != err {
 		log.Error(err)
+	}
+}
+
+// StartReconnect connects to an already paired console at the given
+// MAC address, instead of waiting for it on the pairing screen.
+func (s *Server) StartReconnect(console string) error {
+	toggleCleanBluez(true)
+
+	s.console = console
+	return s.start()
+}
+
+// SetReconnect makes the server dial the paired console at the given
+// MAC address when started by a Manager.
+func (s *Server) SetReconnect(console string) {
+	s.console = console
+}
+
+// Disconnect drops the connection to the console, which is not
+// waited for again. It does nothing while no console is connected.
+func (s *Server) Disconnect() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.peer != "" {
+		s.dropping = true
+	}
+}
+
+// Lag returns for how long reports could not be sent to the console,
+// 0 while it keeps up.
+func (s *Server) Lag() time.Duration {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.stalledSince.IsZero() {
+		return 0
+	}
+	return time.Since(s.stalledSince)
+}
+
+// start sets up the adapter and blocks until a console is connected,
+// BlueZ must already be set up by toggleCleanBluez.
+func (s *Server) start() error {
+	if err := s.Setup(); nil != err {
+		return err
+	}
+
+	var itr, ctrl int
+	if s.console != "" {
+		var err error
+		if itr, ctrl, err = s.Reconnect(); nil != err {
+			return err
+		}
+	} else {
+		itr, ctrl = s.Connect()
+	}
+	go s.serve(itr, ctrl)
+	return nil
+}
+
+// Reconnect dials the control and interrupt channels of the paired
+// console, it already knows the controller so reports can be sent
+// right away.
+func (s *Server) Reconnect() (itr, ctrl int, err error) {
+	if itr, ctrl, err = s.dial(s.console); nil != err {
+		return
+	}
+
+	s.controller.DeviceInfoRequired = true
+	s.controller.Mode = R.StandFullMode
+	s.setPeer(s.console)
+	s.recordConsole(s.console)
+	s.emit(EventConnected)
+	return itr, ctrl, nil
+}
+
+// dialL2cap connects the control and interrupt channels to console,
+// the interrupt one is non-blocking.
+func (s *Server) dialL2cap(console string) (itr, ctrl int, err error) {
+	addr, err := s.device.GetAddress()
+	if nil != err {
 		return
 	}
-	itr, ctrl := s.Connect()
-	go s.Run(itr, ctrl)
+	log.DebugF("Reconnecting %s to %s", addr, console)
+
+	if ctrl, err = DialSocket(addr, console, 17); nil != err {
+		return
+	}
+	if itr, err = DialSocket(addr, console, 19); nil != err {
+		unix.Close(ctrl)
+		return
+	}
+	if err = unix.SetNonblock(itr, true); nil != err {
+		unix.Close(itr)
+		unix.Close(ctrl)
+	}
+	return
+}
+
+// SetForgetOnReset makes the server remove the other paired Switches
+// from BlueZ when the console resets the pairing info.
+func (s *Server) SetForgetOnReset(forget bool) {
+	s.forgetOnReset = forget
+}
+
+// SetConsoleStore saves the consoles the server connects to in store,
+// along with the player slot they assign.
+func (s *Server) SetConsoleStore(store *ConsoleStore) {
+	s.consoles = store
+	s.controller.OnPlayerLights(func(lights C.PlayerLights) {
+		s.recordPlayer(lights.Player())
+	})
+}
+
+// ForgetConsole removes the console from the store and its pairing
+// from BlueZ.
+func (s *Server) ForgetConsole(address string) error {
+	if s.consoles == nil {
+		return ErrUnknownConsole
+	}
+	console, ok := s.consoles.Get(address)
+	if !ok {
+		return ErrUnknownConsole
+	}
+	if err := s.device.RemoveDevice(dbus.ObjectPath(console.DevicePath)); nil != err {
+		log.ErrorF("remove console %s: %v", console.DevicePath, err)
+	}
+	return s.consoles.Forget(address)
+}
+
+func (s *Server) recordConsole(address string) {
+	if s.consoles == nil || address == "" {
+		return
+	}
+	adapter, _ := s.device.GetAddress()
+	console := Console{
+		Address:       address,
+		Adapter:       adapter,
+		DevicePath:    s.device.devicePath + "/dev_" + strings.ReplaceAll(strings.ToUpper(address), ":", "_"),
+		LinkKey:       hasLinkKey(adapter, address),
+		LastConnected: time.Now(),
+	}
+	// A dialed console sets the player lights later, keep the last slot
+	if s.controller.PlayerNumber {
+		console.Player = s.controller.Player()
+	} else if known, ok := s.consoles.Get(address); ok {
+		console.Player = known.Player
+	}
+	if err := s.consoles.Update(console); nil != err {
+		log.ErrorF("save console %s: %v", address, err)
+	}
+}
+
+// recordPlayer updates the player slot of the connected console.
+func (s *Server) recordPlayer(player int) {
+	address := s.Peer()
+	if address == "" || player == 0 {
+		return
+	}
+	s.consoles.SetPlayer(address, player)
+}
+
+// serve runs the connection until the console drops it, then waits
+// for it to connect again. A controller reconnecting to a known
+// console dials it instead. The session ends for good once the
+// console sets the HCI state to disconnect, as when it sleeps.
+func (s *Server) serve(itr, ctrl int) {
+	for {
+		state, err := s.Run(itr, ctrl)
+		unix.Close(itr)
+		unix.Close(ctrl)
+		s.controller.Disconnect()
+		s.setPeer("")
+		s.resetLag()
+		s.emit(EventDisconnected)
+		switch {
+		case err == ErrDropped:
+			log.Debug("Dropped the connection to the console")
+		case nil != err:
+			log.ErrorF("connection to console lost: %v", err)
+		default:
+			log.DebugF("Console set HCI state %s", state)
+		}
+
+		switch {
+		case err == ErrDropped, nil == err && state == R.HciDisconnect:
+			return
+		case nil == err && state == R.HciPair:
+			s.emit(EventPairing)
+		default:
+			if s.console == "" {
+				break
+			}
+			if itr, ctrl, err = s.Reconnect(); nil == err {
+				continue
+			}
+			log.ErrorF("reconnect to %s: %v", s.console, err)
+		}
+		itr, ctrl = s.Connect()
+	}
 }
 
 func (s *Server) Setup() (err error) {
@@ -77,10 +305,11 @@ func (s *Server) Setup() (err error) {
 	if err = s.device.SetDiscoverableTimeout(180); nil != err {
 		return
 	}
-	if err = s.device.SetAlias(ALIAS); nil != err {
+	alias := s.controller.Type.String()
+	if err = s.device.SetAlias(alias); nil != err {
 		return
 	}
-	log.Debug("setting device name to Pro Controller...")
+	log.DebugF("setting device name to %s...", alias)
 
 	options := map[string]interface{}{
 		"ServiceRecord":         sdpRecord,
@@ -89,7 +318,7 @@ func (s *Server) Setup() (err error) {
 		"RequireAuthorization":  false,
 		"AutoConnect":           true,
 	}
-	return s.device.RegisterProfile(HID_PATH, uuid.NewString(), options)
+	return registerProfile(s.device, options)
 }
 
 func (s *Server) Connect() (int, int) {
@@ -116,6 +345,10 @@ func (s *Server) Connect() (int, int) {
 	log.DebugF("Accept control %d from %v", ctrl, ctrlAddr)
 	s.needWatch = false
 
+	// Listen again when the console reconnects
+	unix.Close(itrSock)
+	unix.Close(ctrlSock)
+
 	// stop advertising
 	s.device.SetDiscoverable(false)
 	s.device.SetPairable(false)
@@ -125,7 +358,7 @@ func (s *Server) Connect() (int, int) {
 	}
 
 	// Send an empty input report to the Switch to prompt a reply
-	input := s.protocol.generateStandardReport(s.controller)
+	input := s.protocol.generateReport(s.controller)
 	s.unixWrite(itr, input)
 
 	reportReceived := false
@@ -150,7 +383,7 @@ func (s *Server) Connect() (int, int) {
 				errors.Is(err, R.ErrBadLengthData),
 				errors.Is(err, R.ErrMalformedData),
 				errors.Is(err, R.ErrUnknownOutputId):
-				input = s.protocol.generateStandardReport(s.controller)
+				input = s.protocol.generateReport(s.controller)
 			default:
 				log.ErrorF("error reading output report: %v", err)
 				continue
@@ -161,7 +394,7 @@ func (s *Server) Connect() (int, int) {
 			case R.RumbleAndSubcommand:
 				input = s.protocol.processSubcommandReport(s.controller, s.output)
 			default:
-				input = s.protocol.generateStandardReport(s.controller)
+				input = s.protocol.generateReport(s.controller)
 			}
 		}
 		s.unixWrite(itr, input)
@@ -172,10 +405,16 @@ func (s *Server) Connect() (int, int) {
 		}
 	}
 
+	s.setPeer(sockaddrAddress(ctrlAddr))
+	s.recordConsole(s.Peer())
+	s.emit(EventConnected)
 	return itr, ctrl
 }
 
-func (s *Server) Run(itr, ctrl int) {
+// Run sends input reports until the console sets the HCI state,
+// which is returned once acknowledged, or until the connection is
+// lost or dropped with Disconnect.
+func (s *Server) Run(itr, ctrl int) (R.HciState, error) {
 	tick := 0
 	freq := time.Second / 66
 	timer := time.NewTimer(freq)
@@ -185,41 +424,168 @@ func (s *Server) Run(itr, ctrl int) {
 		tick++
 		timer.Reset(freq)
 
+		if s.dropRequested() {
+			return 0, ErrDropped
+		}
+
+		// Consume changes before building the report, 0x3F reports
+		// take the input when generated
+		buttons, changed := s.controller.DumpChanged()
+		if changed {
+			s.stateUpdated = true
+		}
+
 		var err error
 		var input *R.InputReport
-		if _, err = s.unixRead(itr, s.output); err == nil {
+		var state R.HciState
+		closing := false
+		var n int
+		if n, err = s.unixRead(itr, s.output); err == nil && n == 0 {
+			err = io.EOF // Closed by the console
+		} else if err == nil {
 			err = s.output.Validate()
 		}
-		if err != nil {
-			input = s.protocol.generateStandardReport(s.controller)
+		if connectionLost(err) {
+			return 0, err
+		} else if err != nil {
+			input = s.protocol.generateReport(s.controller)
 		} else {
 			switch s.output.Id() {
 			case R.RumbleAndSubcommand:
+				s.controller.UpdateRumble(s.output.Rumble())
 				input = s.protocol.processSubcommandReport(s.controller, s.output)
 				log.DebugF("MainLoop RumbleAndSubcommand: %s", s.output)
 				s.stateUpdated = true
-			case R.RumbleOnly, R.UpdateNfcPacket:
-				input = s.protocol.generateStandardReport(s.controller)
-			case R.RequestNfcData:
-				s.protocol.processNfcDataReport(s.controller, s.output)
+				state, closing = s.handleLifecycle(s.output)
+			case R.RumbleOnly:
+				s.controller.UpdateRumble(s.output.Rumble())
+				input = s.protocol.generateReport(s.controller)
+			case R.RequestNfcData, R.UpdateNfcPacket:
+				input = s.protocol.processNfcDataReport(s.controller, s.output)
 				log.DebugF("MainLoop RequestNFCData: %s", s.output)
-				continue
+				s.stateUpdated = true
+			default:
+				input = s.protocol.generateReport(s.controller)
 			}
 		}
-		if s.controller.Dirty {
-			b := s.controller.Dump()
-			input.SetButtonState(b)
-			s.stateUpdated = true
+		// Buttons and sticks are held state, carry them in every report
+		if input.Id() != R.SimpleHidId {
+			input.SetButtonState(buttons)
+			left, right := s.controller.StickState()
+			input.SetStickState(left[:], right[:])
 		}
-		if s.stateUpdated {
-			_, err := s.unixWrite(itr, input)
-			log.DebugF("MainLoop Update %s %v", input, err)
+		// MCU data is consumed by each 0x31 and 0x23 report, never
+		// drop one
+		if s.stateUpdated || input.Id() == R.NfcMcuModeId || input.Id() == R.McuUpdateId {
+			log.DebugF("MainLoop Update %s", input)
+			_, err = s.unixWrite(itr, input)
 			s.stateUpdated = false
 		} else if tick >= 132 {
-			_, _ = s.unixWrite(itr, input)
+			_, err = s.unixWrite(itr, input)
 			tick = 0
 		}
+		if connectionLost(err) {
+			return 0, err
+		}
+		if closing {
+			return state, nil
+		}
+	}
+	return R.HciDisconnect, nil
+}
+
+// connectionLost tells if an error reading or writing a report means
+// the link is gone, rather than no data yet or a malformed report.
+func connectionLost(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, syscall.EAGAIN),
+		errors.Is(err, R.ErrBadLengthData),
+		errors.Is(err, R.ErrMalformedData),
+		errors.Is(err, R.ErrUnknownOutputId):
+		return false
+	default:
+		return true
+	}
+}
+
+// handleLifecycle applies subcommands about the connection once they
+// are answered, closing tells if the console asked to drop it.
+func (s *Server) handleLifecycle(output R.OutputReport) (state R.HciState, closing bool) {
+	args := output.SubcommandArgs()
+	switch output.Subcommand() {
+	case R.SetHciState:
+		return R.HciState(args[0]), true
+	case R.ResetPairingInfo:
+		if s.forgetOnReset {
+			s.forgetConsoles()
+		}
+		s.emit(EventPairingReset)
+	case R.SetShipmentLowPowerState:
+		if args[0] == 0x01 {
+			s.emit(EventLowPower)
+		}
 	}
+	return 0, false
+}
+
+// forgetConsoles removes the paired Switches from BlueZ, except the
+// connected one as removing it would drop the link.
+func (s *Server) forgetConsoles() {
+	paths, err := s.device.PrepairedSwitches()
+	if nil != err {
+		log.ErrorF("list paired consoles: %v", err)
+		return
+	}
+	connected, _ := s.device.FindConnectedAdapter()
+	for _, path := range paths {
+		if slices.Contains(connected, string(path)) {
+			continue
+		}
+		if err := s.device.RemoveDevice(path); nil != err {
+			log.ErrorF("remove console %s: %v", path, err)
+		}
+	}
+}
+
+func (s *Server) Controller() *C.Controller {
+	return s.controller
+}
+
+// Adapter returns the hci name of the adapter used.
+func (s *Server) Adapter() string {
+	return s.device.deviceId
+}
+
+// Peer returns the address of the connected console, "" if none.
+func (s *Server) Peer() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.peer
+}
+
+func (s *Server) setPeer(address string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.peer = address
+}
+
+// OnRumble registers a handler for rumble sent by the console, see
+// Controller.OnRumble.
+func (s *Server) OnRumble(handler func(R.RumbleData)) {
+	s.controller.OnRumble(handler)
+}
+
+// OnPlayerLights registers a handler for player lights set by the
+// console, see Controller.OnPlayerLights.
+func (s *Server) OnPlayerLights(handler func(C.PlayerLights)) {
+	s.controller.OnPlayerLights(handler)
+}
+
+// OnHomeLight registers a handler for the HOME LED pattern set by
+// the console, see Controller.OnHomeLight.
+func (s *Server) OnHomeLight(handler func(C.HomeLight)) {
+	s.controller.OnHomeLight(handler)
 }
 
 func (s *Server) unixRead(fd int, output R.OutputReport) (int, error) {
@@ -234,7 +600,27 @@ func (s *Server) unixWrite(fd int, input *R.InputReport) (int, error) {
 		s.mux.Unlock()
 		FreeReport(input)
 	}()
-	return unix.Write(fd, *input)
+	n, err := unix.Write(fd, *input)
+	if nil == err {
+		s.stalledSince = time.Time{}
+	} else if s.stalledSince.IsZero() {
+		s.stalledSince = time.Now()
+	}
+	return n, err
+}
+
+func (s *Server) resetLag() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stalledSince = time.Time{}
+}
+
+func (s *Server) dropRequested() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	dropping := s.dropping
+	s.dropping = false
+	return dropping
 }
 
 func (s *Server) watchConnReset() {
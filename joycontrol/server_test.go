@@ -0,0 +1,257 @@
+package joycontrol
+
+import (
+	"testing"
+	"time"
+
+	C "dio.wtf/joycontrol/joycontrol/controller"
+	R "dio.wtf/joycontrol/joycontrol/report"
+	"golang.org/x/sys/unix"
+)
+
+// newTestServer runs controller over a socket pair instead of L2CAP
+// channels, the console end is returned.
+func newTestServer(t *testing.T, controller *C.Controller) (s *Server, itr, console int) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = unix.SetNonblock(fds[0], true); nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unix.Close(fds[1]) })
+
+	s = &Server{
+		protocol:   NewProtocol(nil),
+		controller: controller,
+		peer:       "98:B6:E9:00:00:00",
+		output:     make([]byte, R.OutputReportLength),
+	}
+	return s, fds[0], fds[1]
+}
+
+// readReport waits up to timeout for an input report sent to the
+// console, nil if none came.
+func readReport(t *testing.T, console int, timeout time.Duration) []byte {
+	fds := []unix.PollFd{{Fd: int32(console), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		return nil
+	}
+	buf := make([]byte, nfcSize)
+	n, err = unix.Read(console, buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestRunSendsImuSamples(t *testing.T) {
+	ctrl := C.NewController(C.ProController)
+	ctrl.Mode = R.StandFullMode
+	ctrl.ImuEnabled = true
+	s, itr, console := newTestServer(t, ctrl)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(itr, itr)
+		close(done)
+	}()
+	defer func() {
+		s.Disconnect()
+		<-done
+		unix.Close(itr)
+	}()
+
+	if report := readReport(t, console, 100*time.Millisecond); report != nil {
+		t.Fatalf("got report % X without input", report[:4])
+	}
+	ctrl.PushImuSamples(C.ImuSample{Gyro: C.Vector3{X: -70}})
+	report := readReport(t, console, 100*time.Millisecond)
+	if report == nil || R.InputReportId(report[1]) != R.StandardFullModeId {
+		t.Fatal("no report sent for new IMU samples")
+	}
+	// Latest sample of the IMU data at byte 14
+	if imu := report[14:50]; imu[30] != 0x18 || imu[31] != 0xFC {
+		t.Errorf("got gyro X % X", imu[30:32])
+	}
+}
+
+func TestRunSendsSimpleHidInput(t *testing.T) {
+	ctrl := C.NewController(C.ProController)
+	ctrl.Mode = R.SimpleHidMode
+	s, itr, console := newTestServer(t, ctrl)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(itr, itr)
+		close(done)
+	}()
+	defer func() {
+		s.Disconnect()
+		<-done
+		unix.Close(itr)
+	}()
+
+	ctrl.Press("A")
+	report := readReport(t, console, 100*time.Millisecond)
+	if report == nil || R.InputReportId(report[1]) != R.SimpleHidId {
+		t.Fatal("no simple HID report sent for pressed button")
+	}
+	if report[2]&0x02 == 0 {
+		t.Errorf("got buttons % X, expect A", report[2:4])
+	}
+}
+
+func TestRunSendsMcuReports(t *testing.T) {
+	for _, mode := range []R.InputReportMode{R.NfcMode, R.McuUpdateMode} {
+		ctrl := C.NewController(C.ProController)
+		ctrl.Mode = mode
+		s, itr, console := newTestServer(t, ctrl)
+
+		done := make(chan struct{})
+		go func() {
+			s.Run(itr, itr)
+			close(done)
+		}()
+
+		// Without input changes, every report carries MCU data
+		for i := 0; i < 3; i++ {
+			if report := readReport(t, console, 100*time.Millisecond); report == nil {
+				t.Errorf("mode %02X: report %d not sent", mode, i)
+				break
+			}
+		}
+		s.Disconnect()
+		<-done
+		unix.Close(itr)
+	}
+}
+
+func TestRunReturnsOnConnectionLost(t *testing.T) {
+	ctrl := C.NewController(C.ProController)
+	ctrl.Mode = R.StandFullMode
+	s, itr, console := newTestServer(t, ctrl)
+	defer unix.Close(itr)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.Run(itr, itr)
+		errs <- err
+	}()
+
+	unix.Shutdown(console, unix.SHUT_RDWR)
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("expect an error when the console closes the connection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run kept going after the connection was closed")
+	}
+}
+
+func TestServeRedialsLostConsole(t *testing.T) {
+	ctrl := C.NewController(C.ProController)
+	ctrl.Mode = R.StandFullMode
+	s, itr, console := newTestServer(t, ctrl)
+	s.console = "98:B6:E9:00:00:01"
+
+	// Redialing hands out a new socket pair in place of L2CAP channels
+	redialed := make(chan int, 1)
+	s.dial = func(address string) (int, int, error) {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+		if nil != err {
+			return 0, 0, err
+		}
+		unix.SetNonblock(fds[0], true)
+		control, _ := unix.Dup(fds[0])
+		redialed <- fds[1]
+		return fds[0], control, nil
+	}
+	events := make(chan Event, 4)
+	s.OnEvent(func(event Event) { events <- event })
+
+	ctrlFd, err := unix.Dup(itr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.serve(itr, ctrlFd)
+		close(done)
+	}()
+
+	// The console leaves range
+	unix.Shutdown(console, unix.SHUT_RDWR)
+	for _, expect := range []Event{EventDisconnected, EventConnected} {
+		select {
+		case event := <-events:
+			if event != expect {
+				t.Fatalf("got event %s, expect %s", event, expect)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", expect)
+		}
+	}
+	peer := <-redialed
+	defer unix.Close(peer)
+	if s.Peer() != s.console {
+		t.Errorf("got peer %q, expect %q", s.Peer(), s.console)
+	}
+
+	ctrl.Press("A")
+	if report := readReport(t, peer, 100*time.Millisecond); report == nil {
+		t.Error("no report sent over the redialed connection")
+	}
+
+	s.Disconnect()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("serve kept running after Disconnect")
+	}
+}
+
+func TestDisconnectWithoutConsole(t *testing.T) {
+	s, itr, _ := newTestServer(t, C.NewController(C.ProController))
+	defer unix.Close(itr)
+
+	// Waiting for a console, the next one must not be dropped
+	s.setPeer("")
+	s.Disconnect()
+	if s.dropRequested() {
+		t.Error("drop requested without a connected console")
+	}
+}
+
+func TestPairingResetKeepsDevices(t *testing.T) {
+	s, itr, _ := newTestServer(t, C.NewController(C.ProController))
+	defer unix.Close(itr)
+	var events []Event
+	s.OnEvent(func(event Event) { events = append(events, event) })
+
+	// Without SetForgetOnReset BlueZ is left alone, the test server
+	// has no device to remove them from
+	output := R.OutputReport(make([]byte, R.OutputReportLength))
+	output[11] = byte(R.ResetPairingInfo)
+	if _, closing := s.handleLifecycle(output); closing {
+		t.Error("pairing reset closes the connection")
+	}
+	if len(events) != 1 || events[0] != EventPairingReset {
+		t.Errorf("got events %v", events)
+	}
+}
+
+func TestRunDropped(t *testing.T) {
+	s, itr, _ := newTestServer(t, C.NewController(C.ProController))
+	defer unix.Close(itr)
+
+	s.Disconnect()
+	if _, err := s.Run(itr, itr); err != ErrDropped {
+		t.Errorf("got %v, expect ErrDropped", err)
+	}
+}
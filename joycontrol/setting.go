@@ -52,6 +52,11 @@ func toggleCleanBluez(flag bool) {
 
 	cmd.Exec("systemctl", "daemon-reload")
 	cmd.Exec("systemctl", "restart", "bluetooth")
+
+	// Profiles do not survive a restart of bluetoothd
+	profileMux.Lock()
+	profileRegistered = false
+	profileMux.Unlock()
 	log.Debug("systemd found and bluetooth reloaded")
 }
 
@@ -31,6 +31,39 @@ func SetupSocket(addr string, channel uint16) (fd int, err error) {
 	return
 }
 
+// DialSocket connects an L2CAP channel from the adapter at local to
+// the device at remote.
+func DialSocket(local, remote string, channel uint16) (fd int, err error) {
+	fd, err = unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET, unix.BTPROTO_L2CAP)
+	if nil != err {
+		err = fmt.Errorf("unix.Socket %s", err)
+		return
+	}
+	defer func() {
+		if nil != err {
+			unix.Close(fd)
+		}
+	}()
+
+	la, err := ParseSockaddr(local, channel)
+	if nil != err {
+		return
+	}
+	if err = unix.Bind(fd, la); nil != err {
+		err = fmt.Errorf("unix.Bind %s", err)
+		return
+	}
+	ra, err := ParseSockaddr(remote, channel)
+	if nil != err {
+		return
+	}
+	if err = unix.Connect(fd, ra); nil != err {
+		err = fmt.Errorf("unix.Connect %s: %s", remote, err)
+		return
+	}
+	return
+}
+
 var errInvalidMAC = errors.New("bluetooth: Bad MAC address")
 
 func ParseSockaddr(addr string, channel uint16) (unix.Sockaddr, error) {
@@ -39,7 +72,7 @@ func ParseSockaddr(addr string, channel uint16) (unix.Sockaddr, error) {
 	if len(hwAddr) != 6 {
 		return nil, errInvalidMAC
 	}
-	copy(d[:], d[:6])
+	copy(d[:], hwAddr)
 	sa := &unix.SockaddrL2{
 		PSM:      channel,
 		Addr:     d,
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestParseBluetoothSockaddr(t *testing.T) {
@@ -18,6 +20,20 @@ func TestParseBluetoothSockaddr(t *testing.T) {
 	t.Log([]byte(hwAddr))
 }
 
+func TestParseSockaddr(t *testing.T) {
+	sa, err := ParseSockaddr("DC:A6:32:C4:DC:93", 17)
+	if nil != err {
+		t.Fatal(err)
+	}
+	l2 := sa.(*unix.SockaddrL2)
+	if l2.PSM != 17 || l2.Addr != [6]byte{0xDC, 0xA6, 0x32, 0xC4, 0xDC, 0x93} {
+		t.Errorf("got %d %X", l2.PSM, l2.Addr)
+	}
+	if _, err := ParseSockaddr("DC:A6", 17); err == nil {
+		t.Error("expect error on bad MAC")
+	}
+}
+
 func TestHexString(t *testing.T) {
 	data := []byte{1, 0, 255, 0, 8, 0, 27, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 200}
 
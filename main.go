@@ -1,13 +1,19 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"image"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"dio.wtf/joycontrol/joycontrol"
+	"dio.wtf/joycontrol/joycontrol/amiibo"
 	C "dio.wtf/joycontrol/joycontrol/controller"
+	"dio.wtf/joycontrol/joycontrol/ff"
 	tea "github.com/charmbracelet/bubbletea"
 	"golang.org/x/exp/slices"
 )
@@ -17,21 +23,52 @@ type model struct {
 	current    string
 	lastAction string
 
-	controller *C.Controller
+	controllers []*C.Controller
+	selected    int
+	manager     *joycontrol.Manager
+	mirror      *joycontrol.Broadcast
+
+	library       *amiibo.Library
+	amiiboBackups int
+	amiiboKeys    *amiibo.Keys
+	randomUID     bool
+	amiiboStatus  string
 }
 
+type statusTick struct{}
+
 func (m model) Init() tea.Cmd {
+	if m.manager != nil {
+		return refreshStatus()
+	}
 	return nil
 }
 
+// refreshStatus redraws the controllers status as consoles connect.
+func refreshStatus() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return statusTick{}
+	})
+}
+
+// controller returns the controller receiving input.
+func (m model) controller() *C.Controller {
+	if m.mirror != nil {
+		return m.controllers[0]
+	}
+	return m.controllers[m.selected]
+}
+
 func (m model) Send() {
-	m.controller.Press(m.current)
+	m.controller().Press(m.current)
 	time.Sleep(time.Second / 10)
-	m.controller.Release(m.current)
+	m.controller().Release(m.current)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case statusTick:
+		return m, refreshStatus()
 	case tea.KeyMsg:
 		key := strings.ToUpper(msg.String())
 		switch {
@@ -45,43 +82,420 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case key == "ENTER":
 			go m.Send()
+
+		case key == "TAB" && m.manager != nil:
+			m.selected = (m.selected + 1) % m.manager.Len()
+
+		case key == "DELETE" && m.mirror != nil:
+			m.mirror.Drop(m.selected)
+
+		case key == "]", key == "[":
+			m.swapAmiibo(key == "]")
+
+		case key == "0":
+			placeAmiibo(m.nfcControllers(), nil)
+			m.amiiboStatus = "removed"
 		}
 	}
 
 	return m, nil
 }
 
+// swapAmiibo places the next or previous dump of the library.
+func (m *model) swapAmiibo(next bool) {
+	if m.library == nil || len(m.library.Entries()) == 0 {
+		return
+	}
+	step := -1
+	if next {
+		step = 1
+	}
+	tag, entry, err := m.library.Swap(step)
+	if nil == err && m.randomUID {
+		err = tag.RandomizeUID(m.amiiboKeys)
+	}
+	if nil != err {
+		m.amiiboStatus = err.Error()
+		return
+	}
+	tag.SetBackups(m.amiiboBackups)
+	placeAmiibo(m.nfcControllers(), tag)
+	m.amiiboStatus = entry.String()
+}
+
+// placeAmiibo puts tag on the first controller and copies of it on
+// the others, so a single one saves the writes to the dump.
+func placeAmiibo(controllers []*C.Controller, tag *amiibo.Tag) {
+	for i, c := range controllers {
+		if i > 0 && tag != nil {
+			c.SetNfcTag(tag.Clone())
+		} else {
+			c.SetNfcTag(tag)
+		}
+	}
+}
+
+// nfcControllers returns the controllers amiibo are placed on, the
+// mirrored ones instead of the source which has no console.
+func (m model) nfcControllers() []*C.Controller {
+	if m.mirror == nil {
+		return []*C.Controller{m.controller()}
+	}
+	controllers := make([]*C.Controller, m.manager.Len())
+	for i := range controllers {
+		controllers[i] = m.manager.Controller(i)
+	}
+	return controllers
+}
+
 func (m model) View() string {
 	// The header
 	s := "Type next action?\n\n"
 
+	if m.mirror != nil {
+		for i, status := range m.mirror.Status() {
+			s += fmt.Sprintf("%s %s %s: %s\n", cursor(i == m.selected), status.Adapter, status.Type, consoleStatus(status))
+		}
+	} else if m.manager != nil {
+		for i, status := range m.manager.Status() {
+			s += fmt.Sprintf("%s %s %s: %s\n", cursor(i == m.selected), status.Adapter, status.Type, controllerStatus(status))
+		}
+	} else {
+		s += fmt.Sprintf("player: %d\n", m.controller().Player())
+	}
+	if m.amiiboStatus != "" {
+		s += fmt.Sprintf("amiibo: %s\n", m.amiiboStatus)
+	}
 	s += fmt.Sprintf("last action: %s\n", m.lastAction)
 	s += strings.ToUpper(m.current)
 
+	if m.library != nil {
+		s += "\n\nPress [ or ] to swap amiibo, 0 to remove it."
+	}
+	if m.mirror != nil {
+		s += "\n\nPress tab to select the next console, delete to drop it."
+	} else if m.manager != nil {
+		s += "\n\nPress tab to select the next controller."
+	}
 	s += "\n\nPress q to quit.\n"
 
 	return s
 }
 
-func initialModel(controller *C.Controller) model {
+func controllerStatus(status joycontrol.ControllerStatus) string {
+	switch {
+	case status.Err != nil:
+		return status.Err.Error()
+	case status.Connected:
+		return fmt.Sprintf("player %d on %s", status.Player, status.Console)
+	default:
+		return "waiting for a console"
+	}
+}
+
+func consoleStatus(status joycontrol.ConsoleStatus) string {
+	switch {
+	case status.Dropped:
+		return "dropped"
+	case status.Lag > 0:
+		return fmt.Sprintf("%s, lagging %v", controllerStatus(status.ControllerStatus), status.Lag.Round(time.Millisecond))
+	default:
+		return controllerStatus(status.ControllerStatus)
+	}
+}
+
+func cursor(selected bool) string {
+	if selected {
+		return ">"
+	}
+	return " "
+}
+
+func initialModel(controllers []*C.Controller) model {
 	return model{
 		action:     []string{"A", "B", "X", "Y", "L", "ZL", "R", "ZR", "HOME", "UP", "DOWN", "LEFT", "RIGHT"},
 		current:    "",
 		lastAction: "",
 
-		controller: controller,
+		controllers: controllers,
 	}
 }
 
+var controllerTypes = map[string]C.ControllerType{
+	"pro":     C.ProController,
+	"jcl":     C.JoyConL,
+	"jcr":     C.JoyConR,
+	"nes-l":   C.NesL,
+	"nes-r":   C.NesR,
+	"snes":    C.Snes,
+	"n64":     C.N64,
+	"genesis": C.Genesis,
+}
+
 func main() {
-	controller := C.NewController()
-	server := joycontrol.NewServer(controller)
-	server.Start()
-	defer server.Stop()
+	flashPath := flag.String("flash", "spi.bin", "SPI flash image, created with defaults when missing")
+	typeName := flag.String("type", "pro", "Controller type: pro, jcl, jcr, nes-l, nes-r, snes, n64 or genesis")
+	amiiboPath := flag.String("amiibo", "", "Amiibo dump placed on the NFC reader")
+	amiiboBackups := flag.Int("amiibo-backups", 0, "Backups kept of the amiibo dump before it is written")
+	amiiboDir := flag.String("amiibo-dir", "", "Directory of amiibo dumps to swap between while connected")
+	amiiboKeys := flag.String("amiibo-keys", "", "Amiibo master keys, key_retail.bin, to read nicknames and sign random UIDs")
+	randomUID := flag.Bool("amiibo-random-uid", false, "Give amiibo from -amiibo-dir a random UID on each placement, needs -amiibo-keys")
+	irFrames := flag.String("ir-frames", "", "Glob of PNG or raw grayscale frames seen by the IR camera, e.g. 'frames/*.png'")
+	reconnect := flag.String("reconnect", "", "MAC address of an already paired Switch to connect to, or 'default' for the default known console, comma separated for each adapter")
+	mirror := flag.Bool("mirror", false, "Mirror one controller to the consoles of all adapters")
+	adapter := flag.String("adapter", "", "Bluetooth adapter to use, by hci name or address, several comma separated run a controller each")
+	listAdapters := flag.Bool("list-adapters", false, "List Bluetooth adapters and exit")
+	consolesPath := flag.String("consoles", "consoles.json", "Store of the known consoles")
+	forgetOnReset := flag.Bool("forget-on-reset", false, "Remove the other paired Switches from BlueZ when the console resets the pairing info")
+	rumblePath := flag.String("rumble", "", "Force-feedback evdev device to forward rumble to, e.g. /dev/input/event0")
+	colorFlags := map[string]*string{
+		"body":       flag.String("body", "", "Body color as #RRGGBB"),
+		"buttons":    flag.String("buttons", "", "Buttons color as #RRGGBB"),
+		"left-grip":  flag.String("left-grip", "", "Left grip color as #RRGGBB"),
+		"right-grip": flag.String("right-grip", "", "Right grip color as #RRGGBB"),
+	}
+	flag.Parse()
 
-	p := tea.NewProgram(initialModel(controller))
+	if *listAdapters {
+		adapters, err := joycontrol.ListAdapters()
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		for _, a := range adapters {
+			fmt.Printf("%s\t%s\tpowered: %v\t%s\n", a.Name, a.Address, a.Powered, a.Alias)
+		}
+		return
+	}
+
+	controllerType, ok := controllerTypes[*typeName]
+	if !ok {
+		fmt.Printf("Unknown controller type: %s\n", *typeName)
+		os.Exit(1)
+	}
+	adapters := strings.Split(*adapter, ",")
+	controllers := make([]*C.Controller, len(adapters))
+	for i, name := range adapters {
+		path := *flashPath
+		if len(adapters) > 1 {
+			// Each controller needs its own identity
+			ext := filepath.Ext(path)
+			path = strings.TrimSuffix(path, ext) + "." + name + ext
+		}
+		controller, err := newController(controllerType, path, colorFlags)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		controllers[i] = controller
+	}
+	controller := controllers[0]
+	// Mirrored controllers all get the amiibo and IR frames
+	attached := controllers[:1]
+	if *mirror {
+		attached = controllers
+	}
+
+	if *amiiboPath != "" {
+		tag, err := amiibo.Load(*amiiboPath)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		tag.SetBackups(*amiiboBackups)
+		placeAmiibo(attached, tag)
+	}
+	if *irFrames != "" {
+		if !controllerType.HasIrCamera() {
+			fmt.Printf("Alas, %s has no IR camera\n", controllerType)
+			os.Exit(1)
+		}
+		frames, err := loadIrFrames(*irFrames)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		for _, c := range attached {
+			c.SetIrFrames(frames)
+		}
+	}
+	if *rumblePath != "" {
+		device, err := ff.Open(*rumblePath)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		forwarder := ff.NewForwarder(device)
+		defer forwarder.Close()
+		// A single device can't play the rumble of several consoles,
+		// only the first one is forwarded
+		controller.OnRumble(forwarder.Handle)
+	}
+
+	consoles, err := joycontrol.OpenConsoleStore(*consolesPath)
+	if nil != err {
+		fmt.Printf("Alas, there's been an error: %v", err)
+		os.Exit(1)
+	}
+	if *reconnect == "default" {
+		console, ok := consoles.DefaultConsole()
+		if !ok {
+			fmt.Println("Alas, there's no known console to reconnect to")
+			os.Exit(1)
+		}
+		*reconnect = console.Address
+	}
+
+	var manager *joycontrol.Manager
+	var broadcast *joycontrol.Broadcast
+	if len(adapters) > 1 {
+		var targets []string
+		if *reconnect != "" {
+			if targets = strings.Split(*reconnect, ","); len(targets) != len(adapters) {
+				fmt.Println("Alas, -reconnect needs a console for each adapter")
+				os.Exit(1)
+			}
+		}
+		manager = joycontrol.NewManager()
+		for i, name := range adapters {
+			server, err := manager.Add(name, controllers[i])
+			if nil != err {
+				fmt.Printf("Alas, there's been an error: %v", err)
+				os.Exit(1)
+			}
+			server.SetConsoleStore(consoles)
+			server.SetForgetOnReset(*forgetOnReset)
+			if targets != nil {
+				server.SetReconnect(targets[i])
+			}
+		}
+		if *mirror {
+			source, err := newController(controllerType, *flashPath, colorFlags)
+			if nil != err {
+				fmt.Printf("Alas, there's been an error: %v", err)
+				os.Exit(1)
+			}
+			if broadcast, err = joycontrol.NewBroadcast(source, manager); nil != err {
+				fmt.Printf("Alas, there's been an error: %v", err)
+				os.Exit(1)
+			}
+			controllers = []*C.Controller{source}
+			broadcast.Start()
+			defer broadcast.Stop()
+		}
+		manager.Start()
+		defer manager.Stop()
+	} else {
+		server, err := joycontrol.NewServer(controller, *adapter)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		server.SetConsoleStore(consoles)
+		server.SetForgetOnReset(*forgetOnReset)
+		if *reconnect != "" {
+			if err := server.StartReconnect(*reconnect); nil != err {
+				server.Stop()
+				fmt.Printf("Alas, there's been an error: %v", err)
+				os.Exit(1)
+			}
+		} else {
+			server.Start()
+		}
+		defer server.Stop()
+	}
+
+	m := initialModel(controllers)
+	m.manager = manager
+	m.mirror = broadcast
+	if *amiiboKeys != "" {
+		keys, err := amiibo.LoadKeys(*amiiboKeys)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		m.amiiboKeys = keys
+	}
+	if *randomUID && m.amiiboKeys == nil {
+		fmt.Println("Alas, -amiibo-random-uid needs -amiibo-keys")
+		os.Exit(1)
+	}
+	if *amiiboDir != "" {
+		library, err := amiibo.OpenLibrary(*amiiboDir, m.amiiboKeys)
+		if nil != err {
+			fmt.Printf("Alas, there's been an error: %v", err)
+			os.Exit(1)
+		}
+		m.library = library
+		m.amiiboBackups = *amiiboBackups
+		m.randomUID = *randomUID
+	}
+
+	p := tea.NewProgram(m)
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
 	}
 }
+
+func newController(t C.ControllerType, flashPath string, colorFlags map[string]*string) (*C.Controller, error) {
+	controller := C.NewController(t)
+	flash, err := C.OpenSpiFlash(flashPath, t)
+	if nil != err {
+		return nil, err
+	}
+	controller.SetFlash(flash)
+	if err := applyColors(controller, colorFlags); nil != err {
+		return nil, err
+	}
+	return controller, nil
+}
+
+func applyColors(controller *C.Controller, flags map[string]*string) error {
+	// Colors not given keep the ones of the loaded flash
+	colors := controller.Colors()
+	fields := map[string]*C.RGB{
+		"body":       &colors.Body,
+		"buttons":    &colors.Buttons,
+		"left-grip":  &colors.LeftGrip,
+		"right-grip": &colors.RightGrip,
+	}
+
+	changed := false
+	for name, value := range flags {
+		if *value == "" {
+			continue
+		}
+		rgb, err := C.ParseRGB(*value)
+		if nil != err {
+			return err
+		}
+		*fields[name] = rgb
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return controller.SetColors(colors)
+}
+
+func loadIrFrames(pattern string) ([]*image.Gray, error) {
+	paths, err := filepath.Glob(pattern)
+	if nil != err {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no ir frame matches %s", pattern)
+	}
+	sort.Strings(paths)
+
+	frames := make([]*image.Gray, 0, len(paths))
+	for _, path := range paths {
+		frame, err := C.LoadIrFrame(path)
+		if nil != err {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}